@@ -1,10 +1,13 @@
 package api
 
 import (
-	"log"
+	"fmt"
 	"net/http"
 	"strconv"
+	"sync/atomic"
+	"time"
 
+	"drexel.edu/todo/config"
 	"drexel.edu/todo/db"
 	"github.com/gin-gonic/gin"
 )
@@ -12,16 +15,20 @@ import (
 // The api package creates and maintains a reference to the data handler
 // this is a good design practice
 type VoterAPI struct {
-	db *db.VoterList
-}
+	db  db.VoterStore
+	cfg config.Config
 
-func New() (*VoterAPI, error) {
-	dbHandler, err := db.NewVoterList()
-	if err != nil {
-		return nil, err
-	}
+	//reported by the health checks
+	started  time.Time
+	requests atomic.Int64
+	errors   atomic.Int64
+}
 
-	return &VoterAPI{db: dbHandler}, nil
+// New creates the API on top of an already configured storage backend,
+// see db.NewVoterStore.  cfg is the configuration the backend was
+// created from.
+func New(store db.VoterStore, cfg config.Config) *VoterAPI {
+	return &VoterAPI{db: store, cfg: cfg, started: time.Now()}
 }
 
 //Below we implement the API functions.  Some of the framework
@@ -33,31 +40,50 @@ func New() (*VoterAPI, error) {
 //	  for example, 200 for OK, 404 for not found, etc.  This is done
 //	  using the c.JSON() function
 //   4) How to return an error code and abort the request.  This is
-//	  done using the c.AbortWithStatus() function
-
-// implementation for GET /todo
-// returns all todos
+//	  done using the abortWith... helpers in problem.go, which wrap
+//	  c.AbortWithStatusJSON() and send an RFC 7807 problem document
+
+// implementation for GET /voters
+// returns one page of voters, see parseVoterQuery for the paging, sort
+// and filter parameters.  The body stays a plain JSON array, the total
+// number of matches and the cursor for the next page are sent back in
+// the X-Total-Count and X-Next-Cursor headers (plus a Link rel="next").
 func (v *VoterAPI) GetAllVoterResources(c *gin.Context) {
 
-	voterList, err := v.db.GetAllVoters()
-	if err != nil {
-		log.Println("Error Getting All Voters: ", err)
-		c.AbortWithStatus(http.StatusNotFound)
+	q, fields := parseVoterQuery(c)
+	if len(fields) > 0 {
+		abortWithProblem(c, http.StatusBadRequest, "invalid query parameters", fields...)
 		return
 	}
-	//Note that the database returns a nil slice if there are no items
-	//in the database.  We need to convert this to an empty slice
-	//so that the JSON marshalling works correctly.  We want to return
-	//an empty slice, not a nil slice. This will result in the json being []
-	if voterList == nil {
-		voterList = make([]db.Voter, 0)
+
+	page, err := v.db.ListVoters(c.Request.Context(), q)
+	if err != nil {
+		abortWithError(c, err)
+		return
 	}
 
-	c.JSON(http.StatusOK, voterList)
+	setPageHeaders(c, page)
+	c.JSON(http.StatusOK, page.Voters)
+}
+
+// setPageHeaders sends the paging information for a listing back in the
+// X-Total-Count, X-Next-Cursor and Link headers
+func setPageHeaders(c *gin.Context, page db.VoterPage) {
+	c.Header("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		next := *c.Request.URL
+		params := next.Query()
+		params.Set("cursor", page.NextCursor)
+		next.RawQuery = params.Encode()
+
+		c.Header("X-Next-Cursor", page.NextCursor)
+		c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
 }
 
 // implementation for GET /todo/:id
-// returns a single todo
+// returns a single todo.  The ETag is the voter's revision, with an
+// If-None-Match that names it the answer is a 304 without a body.
 func (v *VoterAPI) GetSingleVoterResource(c *gin.Context) {
 
 	//Note go is minimalistic, so we have to get the
@@ -66,20 +92,28 @@ func (v *VoterAPI) GetSingleVoterResource(c *gin.Context) {
 	idS := c.Param("id")
 	id64, err := strconv.ParseInt(idS, 10, 32)
 	if err != nil {
-		log.Println("Error converting id to int64: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithInvalidParam(c, "id", "must be an integer")
 		return
 	}
 
 	//Note that ParseInt always returns an int64, so we have to
 	//convert it to an int before we can use it.
-	voter, err := v.db.GetSingleVoterResource(uint(id64))
+	voter, err := v.db.GetSingleVoterResource(c.Request.Context(), uint(id64))
 	if err != nil {
-		log.Println("Item not found: ", err)
-		c.AbortWithStatus(http.StatusNotFound)
+		abortWithError(c, err)
 		return
 	}
 
+	//Voters written before revisions were kept have none until the
+	//migration gives them one, there is nothing to tag them with
+	if voter.Revision != 0 {
+		c.Header("ETag", etag(voter.Revision))
+		if noneMatch(c, voter.Revision) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+
 	//Git will automatically convert the struct to JSON
 	//and set the content-type header to application/json
 	c.JSON(http.StatusOK, voter)
@@ -93,17 +127,15 @@ func (v *VoterAPI) GetVoterHistory(c *gin.Context) {
 	idS := c.Param("id")
 	id64, err := strconv.ParseInt(idS, 10, 32)
 	if err != nil {
-		log.Println("Error converting id to int64: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithInvalidParam(c, "id", "must be an integer")
 		return
 	}
 
 	//Note that ParseInt always returns an int64, so we have to
 	//convert it to an int before we can use it.
-	voter, err := v.db.GetVoterHistory(uint(id64))
+	voter, err := v.db.GetVoterHistory(c.Request.Context(), uint(id64))
 	if err != nil {
-		log.Println("Item not found: ", err)
-		c.AbortWithStatus(http.StatusNotFound)
+		abortWithError(c, err)
 		return
 	}
 
@@ -122,23 +154,20 @@ func (v *VoterAPI) GetVoterPollData(c *gin.Context) {
 	id64_1, err_1 := strconv.ParseInt(idS, 10, 32)
 	id64_2, err_2 := strconv.ParseInt(idP, 10, 32)
 	if err_1 != nil {
-		log.Println("Error converting voterid to int64: ", err_1)
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithInvalidParam(c, "id", "must be an integer")
 		return
 	}
 
 	if err_2 != nil {
-		log.Println("Error converting pollid to int64: ", err_2)
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithInvalidParam(c, "pollid", "must be an integer")
 		return
 	}
 
 	//Note that ParseInt always returns an int64, so we have to
 	//convert it to an int before we can use it.
-	voter, err := v.db.GetVoterPollData(uint(id64_1), uint(id64_2))
+	voter, err := v.db.GetVoterPollData(c.Request.Context(), uint(id64_1), uint(id64_2))
 	if err != nil {
-		log.Println("Item not found: ", err)
-		c.AbortWithStatus(http.StatusNotFound)
+		abortWithError(c, err)
 		return
 	}
 
@@ -157,23 +186,20 @@ func (v *VoterAPI) AddVoterPollData(c *gin.Context) {
 	id64_1, err_1 := strconv.ParseInt(idS, 10, 32)
 	id64_2, err_2 := strconv.ParseInt(idP, 10, 32)
 	if err_1 != nil {
-		log.Println("Error converting voterid to int64: ", err_1)
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithInvalidParam(c, "id", "must be an integer")
 		return
 	}
 
 	if err_2 != nil {
-		log.Println("Error converting voterid to int64: ", err_2)
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithInvalidParam(c, "pollid", "must be an integer")
 		return
 	}
 
 	//Note that ParseInt always returns an int64, so we have to
 	//convert it to an int before we can use it.
-	err2 := v.db.AddVoterPollData(uint(id64_1), uint(id64_2))
+	err2 := v.db.AddVoterPollData(c.Request.Context(), uint(id64_1), uint(id64_2))
 	if err2 != nil {
-		log.Println("Item not found: ", err_1)
-		c.AbortWithStatus(http.StatusNotFound)
+		abortWithError(c, err2)
 		return
 	}
 }
@@ -188,29 +214,27 @@ func (v *VoterAPI) DeletePoll(c *gin.Context) {
 	id64_1, err_1 := strconv.ParseInt(idS, 10, 32)
 	id64_2, err_2 := strconv.ParseInt(idP, 10, 32)
 	if err_1 != nil {
-		log.Println("Error converting voterid to int64: ", err_1)
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithInvalidParam(c, "id", "must be an integer")
 		return
 	}
 
 	if err_2 != nil {
-		log.Println("Error converting voterid to int64: ", err_2)
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithInvalidParam(c, "pollid", "must be an integer")
 		return
 	}
 
 	//Note that ParseInt always returns an int64, so we have to
 	//convert it to an int before we can use it.
-	err2 := v.db.DeletePoll(uint(id64_1), uint(id64_2))
+	err2 := v.db.DeletePoll(c.Request.Context(), uint(id64_1), uint(id64_2))
 	if err2 != nil {
-		log.Println("Item not found: ", err_1)
-		c.AbortWithStatus(http.StatusNotFound)
+		abortWithError(c, err2)
 		return
 	}
 }
 
-// implementation for POST /todo
-// adds a new todo
+// implementation for POST /voters/:id
+// adds a new voter under the id in the path, the answer is a 201 with
+// the voter as stored, its ETag and its URL, the same as CreateVoter
 func (v *VoterAPI) AddVoter(c *gin.Context) {
 	var voter db.Voter
 
@@ -227,48 +251,143 @@ func (v *VoterAPI) AddVoter(c *gin.Context) {
 	//the struct we are binding to.
 
 	if err := c.ShouldBindJSON(&voter); err != nil {
-		log.Println("Error binding JSON: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithBindError(c, err)
 		return
 	}
 
-	if err := v.db.AddVoter(voter); err != nil {
-		log.Println("Error adding item: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	//The id in the path is the one that counts, the body may leave it
+	//out but it may not name a different voter
+	idS := c.Param("id")
+	id64, err := strconv.ParseUint(idS, 10, 32)
+	if err != nil || id64 == 0 {
+		abortWithInvalidParam(c, "id", "must be a positive integer")
+		return
+	}
+	if voter.VoterId == 0 {
+		voter.VoterId = uint(id64)
+	}
+	if voter.VoterId != uint(id64) {
+		abortWithProblem(c, http.StatusBadRequest, "the id in the body does not match the id in the path",
+			FieldError{Field: "id", Message: fmt.Sprintf("must be %d or left out", id64)})
+		return
+	}
+	if voter.VoteHistory == nil {
+		voter.VoteHistory = []db.VoterPoll{}
+	}
+	//A new voter has not replaced any votes yet
+	voter.VoteAudit = nil
+	if err := v.validateVoter(c.Request.Context(), voter); err != nil {
+		abortWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, voter)
+	added, err := v.db.AddVoter(c.Request.Context(), voter)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/voters/%d", added.VoterId))
+	c.Header("ETag", etag(added.Revision))
+	c.JSON(http.StatusCreated, added)
+}
+
+// implementation for POST /voters
+// adds a new voter under an id picked by the server, the answer is a 201
+// with the voter (id included) and its URL in the Location header
+func (v *VoterAPI) CreateVoter(c *gin.Context) {
+	var voter db.Voter
+	if err := c.ShouldBindJSON(&voter); err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+	if voter.VoterId != 0 {
+		abortWithProblem(c, http.StatusBadRequest, "the id is assigned by the server",
+			FieldError{Field: "id", Message: "must be left out, use POST /voters/:id to pick the id yourself"})
+		return
+	}
+	if voter.VoteHistory == nil {
+		voter.VoteHistory = []db.VoterPoll{}
+	}
+	voter.VoteAudit = nil
+	//The id is not known yet, the rules only need it to be there
+	check := voter
+	check.VoterId = 1
+	if err := v.validateVoter(c.Request.Context(), check); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	created, err := v.db.CreateVoter(c.Request.Context(), voter)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/voters/%d", created.VoterId))
+	c.Header("ETag", etag(created.Revision))
+	c.JSON(http.StatusCreated, created)
 }
 
 // implementation for PUT /todo
 // Web api standards use PUT for Updates
+//
+// With If-Match the voter is only replaced if it is still at that
+// revision, otherwise the answer is a 412.  The check and the write are
+// one step in storage, which is why this goes through PatchVoter.  The
+// vote audit and the revision belong to the server, whatever the body
+// says about them is ignored, the same as when a voter is added.
 func (v *VoterAPI) UpdateVoter(c *gin.Context) {
 	var voter db.Voter
 	if err := c.ShouldBindJSON(&voter); err != nil {
-		log.Println("Error binding JSON: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+		abortWithBindError(c, err)
+		return
+	}
+	if voter.VoteHistory == nil {
+		voter.VoteHistory = []db.VoterPoll{}
+	}
+	if err := v.validateVoter(c.Request.Context(), voter); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	revision, ok := ifMatch(c)
+	if !ok {
 		return
 	}
 
-	if err := v.db.UpdateVoter(voter); err != nil {
-		log.Println("Error updating item: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	updated, err := v.db.PatchVoter(c.Request.Context(), voter.VoterId, func(existing *db.Voter) error {
+		if err := existing.CheckRevision(revision); err != nil {
+			return err
+		}
+		voter.VoteAudit = existing.VoteAudit
+		*existing = voter
+		return nil
+	})
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, voter)
+	c.Header("ETag", etag(updated.Revision))
+	c.JSON(http.StatusOK, updated)
 }
 
 // implementation for DELETE /todo/:id
-// deletes a todo
+// deletes a todo, with If-Match only if it is still at that revision
 func (v *VoterAPI) DeleteVoter(c *gin.Context) {
 	idS := c.Param("id")
-	id64, _ := strconv.ParseInt(idS, 10, 32)
+	id64, err := strconv.ParseInt(idS, 10, 32)
+	if err != nil {
+		abortWithInvalidParam(c, "id", "must be an integer")
+		return
+	}
+	revision, ok := ifMatch(c)
+	if !ok {
+		return
+	}
 
-	if err := v.db.DeleteVoter(uint(id64)); err != nil {
-		log.Println("Error deleting item: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	if err := v.db.DeleteVoter(c.Request.Context(), uint(id64), revision); err != nil {
+		abortWithError(c, err)
 		return
 	}
 
@@ -279,9 +398,8 @@ func (v *VoterAPI) DeleteVoter(c *gin.Context) {
 // deletes all todos
 func (v *VoterAPI) DeleteAllVoters(c *gin.Context) {
 
-	if err := v.db.DeleteAll(); err != nil {
-		log.Println("Error deleting all items: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	if err := v.db.DeleteAll(c.Request.Context()); err != nil {
+		abortWithError(c, err)
 		return
 	}
 
@@ -298,17 +416,52 @@ func (v *VoterAPI) CrashSim(c *gin.Context) {
 	panic("Simulating an unexpected crash")
 }
 
-// implementation of GET /health. It is a good practice to build in a
-// health check for your API.  Below the results are just hard coded
-// but in a real API you can provide detailed information about the
-// health of your API with a Health Check
+// implementation of GET /healthz, the liveness probe.  If we can answer
+// at all the process is alive, so this is always a 200, it does not
+// look at redis (that is what /readyz is for) so a redis outage does not
+// get the container restarted for nothing.
 func (v *VoterAPI) HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK,
-		gin.H{
-			"status":             "ok",
-			"version":            "1.0.0",
-			"uptime":             100,
-			"users_processed":    1000,
-			"errors_encountered": 10,
-		})
+	c.JSON(http.StatusOK, v.health("ok"))
+}
+
+// implementation of GET /readyz, the readiness probe.  This checks the
+// storage backend (for redis that it answers and has ReJSON loaded) and
+// answers 503 if it is not usable, so no traffic is routed to us until
+// it is.
+func (v *VoterAPI) ReadyCheck(c *gin.Context) {
+	if err := v.db.Ping(c.Request.Context()); err != nil {
+		requestLogger(c).Warn("readiness check failed", "error", err)
+		body := v.health("unavailable")
+		body["error"] = err.Error()
+		c.JSON(http.StatusServiceUnavailable, body)
+		return
+	}
+
+	c.JSON(http.StatusOK, v.health("ok"))
+}
+
+func (v *VoterAPI) health(status string) gin.H {
+	return gin.H{
+		"status":             status,
+		"store":              v.cfg.Store.Backend,
+		"version":            Version,
+		"commit":             Commit,
+		"uptime":             time.Since(v.started).Round(time.Second).String(),
+		"uptime_seconds":     int64(time.Since(v.started).Seconds()),
+		"requests_processed": v.requests.Load(),
+		"errors_encountered": v.errors.Load(),
+	}
+}
+
+// CountRequests is a middleware that keeps the request and error
+// counters reported by the health checks.  Any 5xx response counts as
+// an error.
+func (v *VoterAPI) CountRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		v.requests.Add(1)
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			v.errors.Add(1)
+		}
+	}
 }
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"drexel.edu/todo/config"
+	"drexel.edu/todo/db"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// testAPI is the API on a fresh memory store, behind the same middleware
+// as the server and with the routes the tests go through
+type testAPI struct {
+	store  db.VoterStore
+	router *gin.Engine
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	store := db.NewMemoryVoterList()
+	v := New(store, config.Default())
+
+	r := gin.New()
+	r.Use(RequestID(), gin.CustomRecovery(v.Recover), v.LimitBody(), v.Idempotency())
+	r.GET("/voters/:id", v.GetSingleVoterResource)
+	r.POST("/voters", v.CreateVoter)
+	r.POST("/voters/:id", v.AddVoter)
+	r.PUT("/voters", v.UpdateVoter)
+	r.PATCH("/voters/:id", v.PatchVoter)
+	r.DELETE("/voters/:id", v.DeleteVoter)
+
+	return &testAPI{store: store, router: r}
+}
+
+// do sends one request through the router.  A body is sent as JSON,
+// headers are given as name, value pairs and may override that.
+func (a *testAPI) do(method, path, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, req)
+	return w
+}
+
+// addVoter stores a voter for a test to work on, at revision 1
+func (a *testAPI) addVoter(t *testing.T, id uint) db.Voter {
+	t.Helper()
+	voter, err := a.store.AddVoter(context.Background(), *db.NewVoter(id, "Ann", "Smith"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return voter
+}
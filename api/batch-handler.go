@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"drexel.edu/todo/voterio"
+	"github.com/gin-gonic/gin"
+)
+
+// VoterAction answers POST /voters:<action>.  Gin cannot route on a
+// literal colon, so the route is /voters:action and the whole suffix,
+// colon included, arrives as the action parameter.
+func (v *VoterAPI) VoterAction(c *gin.Context) {
+	switch c.Param("action") {
+	case ":batch":
+		v.ImportVoters(c)
+	default:
+		v.NoRoute(c)
+	}
+}
+
+// implementation for POST /voters:batch
+// adds a whole voter roll in one request.  The body is CSV or NDJSON (a
+// JSON array works too), picked by ?format= or the Content-Type.  The
+// voters are written in batches as the body is read, so the answer is a
+// report with the outcome of every record rather than all or nothing.
+// Add ?dryrun=true to get the report without writing anything.
+func (v *VoterAPI) ImportVoters(c *gin.Context) {
+	format := c.Query("format")
+	if format == "" {
+		format = voterio.FormatFromContentType(c.ContentType())
+	}
+	if format == "" {
+		abortWithProblem(c, http.StatusUnsupportedMediaType,
+			"send text/csv or application/x-ndjson, or pass ?format=csv|ndjson|json")
+		return
+	}
+	dec, err := voterio.NewDecoder(c.Request.Body, format)
+	if err != nil {
+		abortWithInvalidParam(c, "format", err.Error())
+		return
+	}
+
+	rules, _ := v.cfg.VotingRules()
+	opts := voterio.ImportOptions{Rules: rules}
+	if s := c.Query("dryrun"); s != "" {
+		dryRun, err := strconv.ParseBool(s)
+		if err != nil {
+			abortWithInvalidParam(c, "dryrun", "must be true or false")
+			return
+		}
+		opts.DryRun = dryRun
+	}
+
+	report, err := voterio.Import(c.Request.Context(), v.db, dec, opts)
+	if err != nil && statusForError(err) != http.StatusInternalServerError {
+		abortWithError(c, err)
+		return
+	}
+	if err != nil {
+		//The body broke off part way, the batches read before that are
+		//already written so say how far it got
+		abortWithBindError(c, fmt.Errorf("body unreadable after %d records, %d voters were imported: %w",
+			len(report.Records), report.Created, err))
+		return
+	}
+
+	requestLogger(c).Info("voters imported", "dryrun", report.DryRun, "created", report.Created,
+		"duplicates", report.Duplicates, "rejected", report.Rejected)
+	c.JSON(http.StatusOK, report)
+}
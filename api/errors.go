@@ -0,0 +1,38 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"drexel.edu/todo/db"
+)
+
+// StatusClientClosedRequest is reported (and logged) when the client
+// hung up before we could answer, nobody is left to read it.  The code
+// is the one nginx uses for the same thing.
+const StatusClientClosedRequest = 499
+
+// statusForError maps the errors reported by the db package to the
+// HTTP status code the client should see.  Anything we do not recognize
+// is treated as an internal error.
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, db.ErrVoterNotFound), errors.Is(err, db.ErrPollNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrInvalidQuery), errors.Is(err, db.ErrInvalidPoll), errors.Is(err, db.ErrInvalidVoter):
+		return http.StatusBadRequest
+	case errors.Is(err, db.ErrVoterExists), errors.Is(err, db.ErrAlreadyVoted),
+		errors.Is(err, db.ErrPollExists), errors.Is(err, db.ErrPollNotOpen):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrRevisionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, db.ErrStorageUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, db.ErrTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, db.ErrCanceled):
+		return StatusClientClosedRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/voterio"
+	"github.com/gin-gonic/gin"
+)
+
+// exportDeadlineEvery is how many voters are written between pushing
+// the write deadline out again
+const exportDeadlineEvery = 500
+
+// exportFormat picks the export format, ?format= wins over the Accept
+// header and NDJSON is the default
+func exportFormat(c *gin.Context) (string, bool) {
+	if f := c.Query("format"); f != "" {
+		f = strings.ToLower(f)
+		switch f {
+		case voterio.FormatJSON, voterio.FormatNDJSON, voterio.FormatCSV:
+			return f, true
+		}
+		return "", false
+	}
+	for _, accept := range strings.Split(c.GetHeader("Accept"), ",") {
+		if f := voterio.FormatFromContentType(accept); f != "" {
+			return f, true
+		}
+	}
+	return voterio.FormatNDJSON, true
+}
+
+// wantsGzip is true for ?gzip=true, or an Accept-Encoding that lists gzip
+func wantsGzip(c *gin.Context) bool {
+	if s := c.Query("gzip"); s != "" {
+		b, _ := strconv.ParseBool(s)
+		return b
+	}
+	for _, enc := range strings.Split(c.GetHeader("Accept-Encoding"), ",") {
+		name, q, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.EqualFold(name, "gzip") && strings.ReplaceAll(q, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// implementation for GET /voters/export
+// streams every voter matching the same filters as GET /voters (lastname,
+// poll, voted_after, voted_before) as NDJSON, CSV with one row per vote,
+// or a JSON array.  The voters are read from storage and written out one
+// at a time so memory use does not grow with the size of the roll.
+// There is no paging, and the order is whatever order storage hands the
+// voters out in.
+//
+// Once the first voter is on the wire the status can no longer change,
+// a storage failure after that cuts the connection so the client sees a
+// truncated download rather than one that looks complete.
+func (v *VoterAPI) ExportVoters(c *gin.Context) {
+	q, fields := parseVoterQuery(c)
+	for _, param := range []string{"limit", "cursor", "sort"} {
+		if c.Query(param) != "" {
+			fields = append(fields, FieldError{Field: param, Message: "is not supported by the export"})
+		}
+	}
+	format, ok := exportFormat(c)
+	if !ok {
+		fields = append(fields, FieldError{Field: "format", Message: "must be one of json, ndjson, csv"})
+	}
+	if len(fields) > 0 {
+		abortWithProblem(c, http.StatusBadRequest, "invalid query parameters", fields...)
+		return
+	}
+
+	var enc voterio.Encoder
+	var zw *gzip.Writer
+	start := func() {
+		c.Header("Content-Type", voterio.ContentType(format))
+		c.Header("Content-Disposition", `attachment; filename="voters.`+format+`"`)
+		c.Header("Vary", "Accept, Accept-Encoding")
+		var w io.Writer = c.Writer
+		if wantsGzip(c) {
+			c.Header("Content-Encoding", "gzip")
+			zw = gzip.NewWriter(c.Writer)
+			w = zw
+		}
+		c.Status(http.StatusOK)
+		enc, _ = voterio.NewEncoder(w, format)
+	}
+
+	//The server's write timeout is meant for ordinary requests, an
+	//export keeps going as long as the client keeps reading
+	rc := http.NewResponseController(c.Writer)
+	extend := func() {
+		if t := v.cfg.Server.WriteTimeout; t > 0 {
+			_ = rc.SetWriteDeadline(time.Now().Add(t))
+		}
+	}
+
+	n := 0
+	err := v.db.ScanVoters(c.Request.Context(), q, func(voter db.Voter) error {
+		if enc == nil {
+			start()
+		}
+		n++
+		if n%exportDeadlineEvery == 0 {
+			extend()
+		}
+		return enc.Encode(voter)
+	})
+	if err != nil && enc == nil {
+		abortWithError(c, err)
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("export failed part way", "voters", n, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+
+	if enc == nil {
+		start()
+	}
+	if err := enc.Close(); err != nil {
+		requestLogger(c).Warn("cannot finish the export", "voters", n, "error", err)
+		return
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			requestLogger(c).Warn("cannot finish the export", "voters", n, "error", err)
+			return
+		}
+	}
+	requestLogger(c).Info("voters exported", "voters", n, "format", format, "gzip", zw != nil)
+}
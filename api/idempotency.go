@@ -0,0 +1,169 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"drexel.edu/todo/db"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	idempotencyRetryAfterSecs = "1"
+)
+
+// replayedHeaders are the response headers kept with the response, the
+// others (request id, CORS) belong to the request that gets the replay
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// recordingWriter keeps a copy of the body on its way to the client
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency makes a POST sent with an Idempotency-Key header safe to
+// retry.  The first request with a key runs as usual and its response is
+// kept for server.idempotencyttl, a retry with the same key and the same
+// request gets that response again, marked with Idempotent-Replayed,
+// without running the handler a second time.
+//
+// Reusing a key for a different request (another path or body) is a
+// 422, and a retry that arrives while the first request is still
+// running a 409.  Responses in the 5xx range are not kept, the request
+// did not necessarily happen and the retry runs it again.  The bulk
+// import is left out, its bodies are too big to hold on to and it
+// already reports voters that exist as duplicates.
+//
+// Keys are not tied to a client, they have to be unique across all of
+// them, which a random UUID is.
+func (v *VoterAPI) Idempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		ttl := v.cfg.Server.IdempotencyTTL
+		if key == "" || ttl == 0 || c.Request.Method != http.MethodPost || batchRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			abortWithProblem(c, http.StatusBadRequest, "invalid request header",
+				FieldError{Field: IdempotencyKeyHeader, Message: "must be at most 255 characters"})
+			return
+		}
+
+		//The body is read here to fingerprint the request, the handler
+		//gets a copy
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithBindError(c, err)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(c.Request, body)
+
+		//Until there is a response the key is only held as long as the
+		//request can run, a crash in between does not lock it for good
+		hold := ttl
+		if t := v.cfg.Server.WriteTimeout; t > 0 && t < hold {
+			hold = t
+		}
+		record, err := v.db.ReserveIdempotencyKey(c.Request.Context(), key, fingerprint, hold)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if record != nil {
+			v.answerFromRecord(c, record, fingerprint)
+			return
+		}
+
+		//Whatever happens from here the key has to be either saved or
+		//given up, even if the request is canceled or the handler panics
+		ctx := context.WithoutCancel(c.Request.Context())
+		release := func() {
+			if err := v.db.ReleaseIdempotencyKey(ctx, key); err != nil {
+				requestLogger(c).Warn("cannot release idempotency key", "key", key, "error", err)
+			}
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				release()
+				panic(r)
+			}
+		}()
+
+		w := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		status := w.Status()
+		if status >= http.StatusInternalServerError || status == StatusClientClosedRequest {
+			release()
+			return
+		}
+		saved := db.IdempotencyRecord{
+			Fingerprint: fingerprint,
+			Status:      status,
+			Header:      make(map[string]string),
+			Body:        w.body.Bytes(),
+		}
+		for _, name := range replayedHeaders {
+			if value := w.Header().Get(name); value != "" {
+				saved.Header[name] = value
+			}
+		}
+		if err := v.db.SaveIdempotencyKey(ctx, key, saved, ttl); err != nil {
+			//The change was made, a retry will make it again
+			requestLogger(c).Error("cannot save idempotent response", "key", key, "error", err)
+			release()
+		}
+	}
+}
+
+// answerFromRecord answers a request whose key is already taken
+func (v *VoterAPI) answerFromRecord(c *gin.Context, record *db.IdempotencyRecord, fingerprint string) {
+	switch {
+	case record.Fingerprint != fingerprint:
+		abortWithProblem(c, http.StatusUnprocessableEntity,
+			"the Idempotency-Key was already used for a different request",
+			FieldError{Field: IdempotencyKeyHeader, Message: "use a new key for a new request"})
+	case !record.Done():
+		c.Header("Retry-After", idempotencyRetryAfterSecs)
+		abortWithProblem(c, http.StatusConflict, "a request with this Idempotency-Key is still being processed")
+	default:
+		requestLogger(c).Debug("replaying idempotent response", "status", record.Status)
+		for name, value := range record.Header {
+			c.Header(name, value)
+		}
+		c.Header(IdempotentReplayedHeader, "true")
+		c.Status(record.Status)
+		_, _ = c.Writer.Write(record.Body)
+		c.Abort()
+	}
+}
+
+// requestFingerprint identifies a request by its method, URL, content
+// type and body, a retry has to match all of them
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+"\n"+r.URL.RequestURI()+"\n"+r.Header.Get("Content-Type")+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const newVoterBody = `{"firstname":"Ann","lastname":"Smith"}`
+
+// voterCount is how many voters the test store holds
+func (a *testAPI) voterCount(t *testing.T) int {
+	t.Helper()
+	voters, _, err := a.store.VoterCounts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return voters
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	a := newTestAPI(t)
+
+	first := a.do(http.MethodPost, "/voters", newVoterBody, IdempotencyKeyHeader, "k1")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", first.Code, first.Body)
+	}
+	if first.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Errorf("the first request was marked as a replay")
+	}
+
+	retry := a.do(http.MethodPost, "/voters", newVoterBody, IdempotencyKeyHeader, "k1")
+	if retry.Code != http.StatusCreated {
+		t.Fatalf("expected the replay to be a 201, got %d: %s", retry.Code, retry.Body)
+	}
+	if retry.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Errorf("the replay was not marked with %s", IdempotentReplayedHeader)
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("the replay has a different body:\n%s\n%s", first.Body, retry.Body)
+	}
+	for _, name := range replayedHeaders {
+		if retry.Header().Get(name) != first.Header().Get(name) {
+			t.Errorf("%s: expected %q, got %q", name, first.Header().Get(name), retry.Header().Get(name))
+		}
+	}
+	if retry.Header().Get(RequestIDHeader) == first.Header().Get(RequestIDHeader) {
+		t.Errorf("the replay has the request id of the first request")
+	}
+	if n := a.voterCount(t); n != 1 {
+		t.Errorf("expected 1 voter, got %d", n)
+	}
+
+	//A 4xx is an answer too, it is kept and replayed
+	bad := a.do(http.MethodPost, "/voters", `{"firstname":"Ann"}`, IdempotencyKeyHeader, "k2")
+	if bad.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", bad.Code, bad.Body)
+	}
+	retry = a.do(http.MethodPost, "/voters", `{"firstname":"Ann"}`, IdempotencyKeyHeader, "k2")
+	if retry.Code != http.StatusBadRequest || retry.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Errorf("expected a replayed 400, got %d replayed %q", retry.Code, retry.Header().Get(IdempotentReplayedHeader))
+	}
+
+	//Without a key every request runs
+	a.do(http.MethodPost, "/voters", newVoterBody)
+	a.do(http.MethodPost, "/voters", newVoterBody)
+	if n := a.voterCount(t); n != 3 {
+		t.Errorf("expected 3 voters, got %d", n)
+	}
+}
+
+func TestIdempotencyKeyReuse(t *testing.T) {
+	a := newTestAPI(t)
+	a.addVoter(t, 1)
+
+	if w := a.do(http.MethodPost, "/voters", newVoterBody, IdempotencyKeyHeader, "k"); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		header []string
+	}{
+		{name: "body", path: "/voters", body: `{"firstname":"Bea","lastname":"Smith"}`},
+		{name: "path", path: "/voters/7", body: `{"id":7,"firstname":"Ann","lastname":"Smith"}`},
+		{name: "content type", path: "/voters", body: newVoterBody,
+			header: []string{"Content-Type", "application/json; charset=utf-8"}},
+	}
+	for _, test := range tests {
+		w := a.do(http.MethodPost, test.path, test.body, append(test.header, IdempotencyKeyHeader, "k")...)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected 422, got %d: %s", test.name, w.Code, w.Body)
+		}
+	}
+	if n := a.voterCount(t); n != 2 {
+		t.Errorf("expected 2 voters, got %d", n)
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	a := newTestAPI(t)
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	a.router.POST("/slow", func(c *gin.Context) {
+		close(started)
+		<-finish
+		c.JSON(http.StatusCreated, gin.H{"done": true})
+	})
+
+	var wg sync.WaitGroup
+	var first *httptest.ResponseRecorder
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		first = a.do(http.MethodPost, "/slow", `{}`, IdempotencyKeyHeader, "k")
+	}()
+	<-started
+
+	w := a.do(http.MethodPost, "/slow", `{}`, IdempotencyKeyHeader, "k")
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409, got %d: %s", w.Code, w.Body)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Errorf("the 409 has no Retry-After")
+	}
+	//A different request with the key is still a 422, not a 409
+	if w := a.do(http.MethodPost, "/slow", `{"x":1}`, IdempotencyKeyHeader, "k"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d: %s", w.Code, w.Body)
+	}
+
+	close(finish)
+	wg.Wait()
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", first.Code, first.Body)
+	}
+	w = a.do(http.MethodPost, "/slow", `{}`, IdempotencyKeyHeader, "k")
+	if w.Code != http.StatusCreated || w.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Errorf("expected a replayed 201, got %d replayed %q", w.Code, w.Header().Get(IdempotentReplayedHeader))
+	}
+}
+
+// TestIdempotencyRelease checks a request that fails on the server side
+// gives its key up, so the retry runs the handler again
+func TestIdempotencyRelease(t *testing.T) {
+	tests := []struct {
+		name string
+		fail func(c *gin.Context)
+	}{
+		{name: "5xx", fail: func(c *gin.Context) {
+			abortWithProblem(c, http.StatusServiceUnavailable, "not now")
+		}},
+		{name: "client gone", fail: func(c *gin.Context) {
+			c.AbortWithStatus(StatusClientClosedRequest)
+		}},
+		{name: "panic", fail: func(c *gin.Context) {
+			panic("handler failed")
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := newTestAPI(t)
+			calls := 0
+			a.router.POST("/flaky", func(c *gin.Context) {
+				calls++
+				if calls == 1 {
+					test.fail(c)
+					return
+				}
+				c.JSON(http.StatusCreated, gin.H{"calls": calls})
+			})
+
+			w := a.do(http.MethodPost, "/flaky", `{}`, IdempotencyKeyHeader, "k")
+			if w.Code == http.StatusCreated {
+				t.Fatalf("expected the first request to fail, got %d", w.Code)
+			}
+			w = a.do(http.MethodPost, "/flaky", `{}`, IdempotencyKeyHeader, "k")
+			if w.Code != http.StatusCreated || w.Header().Get(IdempotentReplayedHeader) != "" {
+				t.Errorf("expected the retry to run, got %d replayed %q", w.Code, w.Header().Get(IdempotentReplayedHeader))
+			}
+			w = a.do(http.MethodPost, "/flaky", `{}`, IdempotencyKeyHeader, "k")
+			if w.Code != http.StatusCreated || w.Header().Get(IdempotentReplayedHeader) != "true" {
+				t.Errorf("expected the success to be replayed, got %d replayed %q", w.Code, w.Header().Get(IdempotentReplayedHeader))
+			}
+			if calls != 2 {
+				t.Errorf("expected the handler to run twice, it ran %d times", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// batchRoutes are the routes that take a whole voter roll, they are
+// allowed server.maxbatchbytes instead
+var batchRoutes = map[string]bool{
+	"/voters:action": true,
+}
+
+// LimitBody caps the size of request bodies at server.maxbodybytes,
+// reading past the limit fails and the bind error is answered with a
+// 413.  0 means no limit.
+func (v *VoterAPI) LimitBody() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		n := v.cfg.Server.MaxBodyBytes
+		if batchRoutes[c.FullPath()] {
+			n = v.cfg.Server.MaxBatchBytes
+		}
+		if n > 0 && c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, n)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"drexel.edu/todo/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// requestLogger returns the logger for the request, tagged with its id
+// by the RequestID middleware
+func requestLogger(c *gin.Context) *slog.Logger {
+	return logging.FromContext(c.Request.Context())
+}
+
+// AccessLog replaces gin's text logger with one structured line per
+// request.  It has to run after RequestID so the line carries the id.
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelWarn
+		}
+
+		requestLogger(c).LogAttrs(c.Request.Context(), level, "request",
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("client_ip", c.ClientIP()),
+		)
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/patch"
+	"github.com/gin-gonic/gin"
+)
+
+// implementation for PATCH /voters/:id
+// changes some fields of a voter without sending the whole document.
+// The body is a JSON merge patch (application/merge-patch+json) or a JSON
+// patch (application/json-patch+json) against the voter as GET returns
+// it.  The result has to be a valid voter with the same id, the vote
+// audit cannot be changed, and only the fields the patch changed are
+// written.
+//
+// A malformed patch is a 400, and so is one that leaves an invalid
+// voter, the same as a POST or PUT of that voter would be.  A patch that
+// does not fit the voter (a path that is not there) is a 422, and a
+// failed JSON patch test a 409.  With If-Match the patch is only applied to
+// that revision, otherwise the answer is a 412.
+func (v *VoterAPI) PatchVoter(c *gin.Context) {
+	idS := c.Param("id")
+	id64, err := strconv.ParseUint(idS, 10, 32)
+	if err != nil || id64 == 0 {
+		abortWithInvalidParam(c, "id", "must be a positive integer")
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+	p, err := patch.Parse(c.ContentType(), body)
+	if errors.Is(err, patch.ErrUnsupportedFormat) {
+		c.Header("Accept-Patch", patch.AcceptPatch)
+		abortWithProblem(c, http.StatusUnsupportedMediaType,
+			"send application/merge-patch+json or application/json-patch+json")
+		return
+	}
+	if err != nil {
+		abortWithProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	revision, ok := ifMatch(c)
+	if !ok {
+		return
+	}
+	validator := v.voterValidator()
+
+	voter, err := v.db.PatchVoter(c.Request.Context(), uint(id64), func(voter *db.Voter) error {
+		if err := voter.CheckRevision(revision); err != nil {
+			return err
+		}
+		return applyVoterPatch(c.Request.Context(), p, validator, voter)
+	})
+	switch {
+	case errors.Is(err, patch.ErrTestFailed):
+		abortWithProblem(c, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, patch.ErrCannotApply):
+		abortWithProblem(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	case err != nil:
+		abortWithError(c, err)
+		return
+	}
+
+	c.Header("ETag", etag(voter.Revision))
+	c.JSON(http.StatusOK, voter)
+}
+
+// applyVoterPatch applies p to the JSON of voter and, if the outcome is
+// a valid voter by the rules of validator, puts it in place of the
+// original.  Otherwise it returns a *db.ValidationError, which also
+// lists changes to the fields the server owns.
+func applyVoterPatch(ctx context.Context, p patch.Patch, validator *db.VoterValidator, voter *db.Voter) error {
+	doc, err := json.Marshal(voter)
+	if err != nil {
+		return err
+	}
+	doc, err = p.Apply(doc)
+	if err != nil {
+		return err
+	}
+
+	var patched db.Voter
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patched); err != nil {
+		return &db.ValidationError{Fields: patchDecodeErrors(err)}
+	}
+	if patched.VoteHistory == nil {
+		patched.VoteHistory = []db.VoterPoll{}
+	}
+
+	var fields []FieldError
+	if patched.VoterId != voter.VoterId {
+		fields = append(fields, FieldError{Field: "id", Message: "cannot be changed"})
+	}
+	if err := validator.Validate(ctx, patched); err != nil {
+		invalid := validationFields(err)
+		if invalid == nil {
+			return err
+		}
+		fields = append(fields, invalid...)
+	}
+	if patched.Revision != voter.Revision {
+		fields = append(fields, FieldError{Field: "revision", Message: "is read only, use If-Match to patch a given revision"})
+	}
+	before, _ := json.Marshal(voter.VoteAudit)
+	after, _ := json.Marshal(patched.VoteAudit)
+	if !bytes.Equal(before, after) {
+		fields = append(fields, FieldError{Field: "voteaudit", Message: "is read only"})
+	}
+	if len(fields) > 0 {
+		return &db.ValidationError{Fields: fields}
+	}
+
+	*voter = patched
+	return nil
+}
+
+// patchDecodeErrors explains why the patched document is not a voter,
+// the strict decoder reports unknown fields as plain errors
+func patchDecodeErrors(err error) []FieldError {
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return []FieldError{{Field: strings.Trim(name, `"`), Message: "is not a voter field"}}
+	}
+	return fieldErrors(err)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"drexel.edu/todo/patch"
+)
+
+// TestPatchVoterStatus checks how the outcome of a patch maps to the
+// status of the answer, and that nothing is written unless it is a 200
+func TestPatchVoterStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{name: "applied", contentType: patch.JSONPatchContentType,
+			body:   `[{"op":"replace","path":"/firstname","value":"Bea"}]`,
+			status: http.StatusOK},
+		{name: "failed test", contentType: patch.JSONPatchContentType,
+			body:   `[{"op":"test","path":"/firstname","value":"Bob"},{"op":"replace","path":"/firstname","value":"Bea"}]`,
+			status: http.StatusConflict},
+		{name: "cannot apply", contentType: patch.JSONPatchContentType,
+			body:   `[{"op":"remove","path":"/nickname"}]`,
+			status: http.StatusUnprocessableEntity},
+		{name: "malformed patch", contentType: patch.JSONPatchContentType,
+			body:   `[{"op":"frob","path":"/firstname"}]`,
+			status: http.StatusBadRequest},
+		{name: "invalid voter", contentType: patch.MergePatchContentType,
+			body:   `{"firstname":""}`,
+			status: http.StatusBadRequest},
+		{name: "read only field", contentType: patch.MergePatchContentType,
+			body:   `{"revision":7}`,
+			status: http.StatusBadRequest},
+		{name: "unsupported format", contentType: "application/json",
+			body:   `{"firstname":"Bea"}`,
+			status: http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := newTestAPI(t)
+			before := a.addVoter(t, 1)
+
+			w := a.do(http.MethodPatch, "/voters/1", test.body, "Content-Type", test.contentType)
+			if w.Code != test.status {
+				t.Fatalf("expected %d, got %d: %s", test.status, w.Code, w.Body)
+			}
+
+			after, err := a.store.GetSingleVoterResource(context.Background(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case test.status == http.StatusOK && after.FirstName != "Bea":
+				t.Errorf("the patch was not written, firstname is %q", after.FirstName)
+			case test.status != http.StatusOK && after.Revision != before.Revision:
+				t.Errorf("a %d wrote the voter, revision %d became %d", w.Code, before.Revision, after.Revision)
+			}
+			if test.status == http.StatusUnsupportedMediaType && w.Header().Get("Accept-Patch") != patch.AcceptPatch {
+				t.Errorf("expected Accept-Patch %q, got %q", patch.AcceptPatch, w.Header().Get("Accept-Patch"))
+			}
+		})
+	}
+}
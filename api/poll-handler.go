@@ -0,0 +1,176 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"drexel.edu/todo/db"
+	"github.com/gin-gonic/gin"
+)
+
+// implementation for GET /polls
+// returns every poll definition
+func (v *VoterAPI) GetAllPolls(c *gin.Context) {
+
+	pollList, err := v.db.GetAllPolls(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	//Same as with voters, return [] rather than null when there are
+	//no polls
+	if pollList == nil {
+		pollList = make([]db.Poll, 0)
+	}
+
+	c.JSON(http.StatusOK, pollList)
+}
+
+// implementation for GET /polls/:pollid
+func (v *VoterAPI) GetPoll(c *gin.Context) {
+
+	idP := c.Param("pollid")
+	id64, err := strconv.ParseInt(idP, 10, 32)
+	if err != nil {
+		abortWithInvalidParam(c, "pollid", "must be an integer")
+		return
+	}
+
+	poll, err := v.db.GetPollResource(c.Request.Context(), uint(id64))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, poll)
+}
+
+// implementation for POST /polls
+// creates a poll, new polls start out as drafts unless a status is given
+func (v *VoterAPI) AddPoll(c *gin.Context) {
+	var poll db.Poll
+
+	if err := c.ShouldBindJSON(&poll); err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+
+	if err := v.db.AddPollResource(c.Request.Context(), poll); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	created, err := v.db.GetPollResource(c.Request.Context(), poll.PollID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// implementation for PUT /polls/:pollid
+// replaces the poll definition, this is also how a poll is opened,
+// closed or archived
+func (v *VoterAPI) UpdatePoll(c *gin.Context) {
+
+	idP := c.Param("pollid")
+	id64, err := strconv.ParseInt(idP, 10, 32)
+	if err != nil {
+		abortWithInvalidParam(c, "pollid", "must be an integer")
+		return
+	}
+
+	var poll db.Poll
+	if err := c.ShouldBindJSON(&poll); err != nil {
+		abortWithBindError(c, err)
+		return
+	}
+
+	//The id in the body is optional, but if it is there it has to agree
+	//with the url
+	if poll.PollID != 0 && poll.PollID != uint(id64) {
+		abortWithProblem(c, http.StatusBadRequest, "poll id in the body does not match the url",
+			FieldError{Field: "id", Message: "must match the pollid in the url"})
+		return
+	}
+	poll.PollID = uint(id64)
+
+	if err := v.db.UpdatePollResource(c.Request.Context(), poll); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, poll)
+}
+
+// implementation for DELETE /polls/:pollid
+// removes the poll definition, votes already cast stay in the voters
+// histories
+func (v *VoterAPI) DeletePollResource(c *gin.Context) {
+
+	idP := c.Param("pollid")
+	id64, err := strconv.ParseInt(idP, 10, 32)
+	if err != nil {
+		abortWithInvalidParam(c, "pollid", "must be an integer")
+		return
+	}
+
+	if err := v.db.DeletePollResource(c.Request.Context(), uint(id64)); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// implementation for GET /polls/:pollid/voters
+// returns one page of the voters that voted in the poll, ordered by id.
+// Takes the same limit and cursor parameters as GET /voters.
+func (v *VoterAPI) GetPollVoters(c *gin.Context) {
+
+	idP := c.Param("pollid")
+	id64, err := strconv.ParseInt(idP, 10, 32)
+	if err != nil {
+		abortWithInvalidParam(c, "pollid", "must be an integer")
+		return
+	}
+
+	limit := 0
+	if s := c.Query("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > db.MaxPageSize {
+			abortWithInvalidParam(c, "limit", "must be an integer between 1 and "+strconv.Itoa(db.MaxPageSize))
+			return
+		}
+	}
+
+	page, err := v.db.GetPollVoters(c.Request.Context(), uint(id64), limit, c.Query("cursor"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	setPageHeaders(c, page)
+	c.JSON(http.StatusOK, page.Voters)
+}
+
+// implementation for GET /polls/:pollid/count
+// returns how many voters voted in the poll
+func (v *VoterAPI) CountPollVoters(c *gin.Context) {
+
+	idP := c.Param("pollid")
+	id64, err := strconv.ParseInt(idP, 10, 32)
+	if err != nil {
+		abortWithInvalidParam(c, "pollid", "must be an integer")
+		return
+	}
+
+	count, err := v.db.CountPollVoters(c.Request.Context(), uint(id64))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pollid": id64, "count": count})
+}
@@ -0,0 +1,252 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"strings"
+
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	ProblemContentType = "application/problem+json"
+	RequestIDHeader    = "X-Request-ID"
+	MaxRequestIDLength = 128
+
+	requestIDKey = "request_id"
+)
+
+// Problem is an RFC 7807 problem details document.  Every error the API
+// reports is sent back to the client in this shape so the caller can
+// tell why a request failed, not just that it did.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes what is wrong with a single field of the request,
+// this is used for validation failures.  It is the db one so the voter
+// rules can be reported as they are.
+type FieldError = db.FieldError
+
+// problemType returns the problem type URI for an error reported by the
+// db package.  Errors we have no specific type for use about:blank, in
+// which case the title is just the HTTP status text.
+func problemType(err error) (string, string) {
+	switch {
+	case errors.Is(err, db.ErrVoterNotFound):
+		return "urn:voter-api:problem:voter-not-found", "Voter not found"
+	case errors.Is(err, db.ErrPollNotFound):
+		return "urn:voter-api:problem:poll-not-found", "Poll not found"
+	case errors.Is(err, db.ErrVoterExists):
+		return "urn:voter-api:problem:voter-exists", "Voter already exists"
+	case errors.Is(err, db.ErrAlreadyVoted):
+		return "urn:voter-api:problem:already-voted", "Already voted"
+	case errors.Is(err, db.ErrPollExists):
+		return "urn:voter-api:problem:poll-exists", "Poll already exists"
+	case errors.Is(err, db.ErrPollNotOpen):
+		return "urn:voter-api:problem:poll-not-open", "Poll is not open"
+	case errors.Is(err, db.ErrRevisionMismatch):
+		return "urn:voter-api:problem:revision-mismatch", "Voter has changed"
+	case errors.Is(err, db.ErrInvalidPoll):
+		return "urn:voter-api:problem:invalid-poll", "Invalid poll"
+	case errors.Is(err, db.ErrInvalidVoter):
+		return "urn:voter-api:problem:invalid-voter", "Invalid voter"
+	case errors.Is(err, db.ErrStorageUnavailable):
+		return "urn:voter-api:problem:storage-unavailable", "Storage unavailable"
+	case errors.Is(err, db.ErrTimeout):
+		return "urn:voter-api:problem:storage-timeout", "Storage timed out"
+	default:
+		return "about:blank", ""
+	}
+}
+
+// RequestID makes sure every request carries an id.  The caller can
+// supply one with the X-Request-ID header, otherwise, or when the one
+// supplied is not a valid id, one is generated.  The id is echoed back
+// on the response so it can be quoted in bug reports, and it is on
+// every log line written for the request.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+
+		//Everything logged while serving the request, including by the
+		//db package, goes through a logger tagged with the id
+		ctx := c.Request.Context()
+		logger := logging.FromContext(ctx).With(logging.RequestIDKey, id)
+		c.Request = c.Request.WithContext(logging.WithLogger(ctx, logger))
+
+		c.Next()
+	}
+}
+
+// validRequestID takes an id of up to MaxRequestIDLength HTTP token
+// characters (RFC 9110), anything else could be used to flood the logs
+// or smuggle text into them
+func validRequestID(id string) bool {
+	if id == "" || len(id) > MaxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// abortWithProblem stops the request and sends a problem document with
+// the given status back to the client
+func abortWithProblem(c *gin.Context, status int, detail string, fields ...FieldError) {
+	writeProblem(c, Problem{
+		Type:   "about:blank",
+		Status: status,
+		Detail: detail,
+		Errors: fields,
+	})
+}
+
+// abortWithError is abortWithProblem for errors coming back from the
+// db package, the status and type are picked from the error itself and
+// a db.ValidationError is listed field by field.  Server side failures
+// do not echo the raw error, it may contain internal details such as
+// the redis address, it is logged instead.
+func abortWithError(c *gin.Context, err error) {
+	typ, title := problemType(err)
+	status := statusForError(err)
+	detail := err.Error()
+	if status >= http.StatusInternalServerError {
+		requestLogger(c).Error("request failed", "status", status, "error", err)
+		detail = "the request could not be completed, please try again later"
+	} else {
+		requestLogger(c).Debug("request rejected", "status", status, "error", err)
+	}
+	writeProblem(c, Problem{
+		Type:   typ,
+		Title:  title,
+		Status: status,
+		Detail: detail,
+		Errors: validationFields(err),
+	})
+}
+
+// abortWithInvalidParam reports a path or query parameter that could not
+// be parsed, a path parameter is looked for first
+func abortWithInvalidParam(c *gin.Context, name string, msg string) {
+	value, ok := c.Params.Get(name)
+	if !ok {
+		value = c.Query(name)
+	}
+	requestLogger(c).Debug("invalid request parameter", "param", name, "value", value)
+	abortWithProblem(c, http.StatusBadRequest, "invalid request parameter",
+		FieldError{Field: name, Message: msg})
+}
+
+// abortWithBindError reports a request body that could not be bound to
+// the target struct, listing the offending fields where we can
+func abortWithBindError(c *gin.Context, err error) {
+	requestLogger(c).Debug("invalid request body", "error", err)
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		abortWithProblem(c, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit))
+		return
+	}
+	abortWithProblem(c, http.StatusBadRequest, "invalid request body", fieldErrors(err)...)
+}
+
+func writeProblem(c *gin.Context, p Problem) {
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	p.Instance = c.Request.URL.RequestURI()
+	p.RequestID = c.GetString(requestIDKey)
+
+	c.Header("Content-Type", ProblemContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
+
+// fieldErrors pulls per field detail out of the errors that gin's
+// binding can return
+func fieldErrors(err error) []FieldError {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	var validationErrs validator.ValidationErrors
+
+	switch {
+	case errors.As(err, &validationErrs):
+		return db.ValidatorFieldErrors(validationErrs)
+	case errors.As(err, &typeErr) && typeErr.Type == reflect.TypeOf(db.Date("")):
+		return []FieldError{{
+			Field:   typeErr.Field,
+			Message: "must be a string holding a date like " + db.DateLayout,
+		}}
+	case errors.As(err, &typeErr):
+		return []FieldError{{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("must be of type %s", typeErr.Type),
+		}}
+	case errors.As(err, &syntaxErr):
+		return []FieldError{{
+			Field:   "",
+			Message: fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset),
+		}}
+	default:
+		return []FieldError{{Field: "", Message: err.Error()}}
+	}
+}
+
+// NoRoute answers requests for paths the router does not know about
+func (v *VoterAPI) NoRoute(c *gin.Context) {
+	abortWithProblem(c, http.StatusNotFound, "no such resource")
+}
+
+// NoMethod answers requests for a known path with an unsupported method
+func (v *VoterAPI) NoMethod(c *gin.Context) {
+	abortWithProblem(c, http.StatusMethodNotAllowed,
+		fmt.Sprintf("%s is not supported on this resource", c.Request.Method))
+}
+
+// Recover is used with gin.CustomRecovery so a panicking handler still
+// answers with a problem document instead of an empty 500.  A handler
+// that panics with http.ErrAbortHandler wants the connection cut, that
+// is passed on to net/http.
+func (v *VoterAPI) Recover(c *gin.Context, recovered any) {
+	if recovered == http.ErrAbortHandler {
+		panic(recovered)
+	}
+	requestLogger(c).Error("handler panicked", "panic", recovered, "stack", string(debug.Stack()))
+	abortWithProblem(c, http.StatusInternalServerError, "unexpected server error")
+}
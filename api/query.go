@@ -0,0 +1,83 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/gin-gonic/gin"
+)
+
+// parseVoterQuery builds a db.VoterQuery from the query string of a
+// listing request:
+//
+//	limit          page size, defaults to db.DefaultPageSize
+//	cursor         the next cursor from a previous page
+//	sort           id, lastname, firstname or lastvote, "-" for descending
+//	lastname       last name prefix, case insensitive
+//	district       only voters in this district, case insensitive
+//	precinct       only voters in this precinct, case insensitive
+//	poll           only voters that voted in this poll
+//	voted_after    only voters with a vote on or after this time
+//	voted_before   only voters with a vote on or before this time
+//
+// Times are RFC 3339, or a plain date (2006-01-02).  A plain voted_before
+// date includes the whole day.
+func parseVoterQuery(c *gin.Context) (db.VoterQuery, []FieldError) {
+	var q db.VoterQuery
+	var fields []FieldError
+
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > db.MaxPageSize {
+			fields = append(fields, FieldError{Field: "limit",
+				Message: "must be an integer between 1 and " + strconv.Itoa(db.MaxPageSize)})
+		}
+		q.Limit = n
+	}
+
+	q.Cursor = c.Query("cursor")
+	q.Sort = c.Query("sort")
+	switch strings.TrimPrefix(q.Sort, "-") {
+	case "", db.SortByID, db.SortByLastName, db.SortByFirstName, db.SortByLastVote:
+	default:
+		fields = append(fields, FieldError{Field: "sort",
+			Message: "must be one of id, lastname, firstname, lastvote, optionally prefixed with -"})
+	}
+	q.LastNamePrefix = c.Query("lastname")
+	q.District = c.Query("district")
+	q.Precinct = c.Query("precinct")
+
+	if s := c.Query("poll"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			fields = append(fields, FieldError{Field: "poll", Message: "must be an integer"})
+		}
+		q.VotedInPoll = uint(n)
+	}
+
+	var err error
+	if q.VotedAfter, err = parseQueryTime(c.Query("voted_after"), false); err != nil {
+		fields = append(fields, FieldError{Field: "voted_after", Message: "must be an RFC 3339 time or a date"})
+	}
+	if q.VotedBefore, err = parseQueryTime(c.Query("voted_before"), true); err != nil {
+		fields = append(fields, FieldError{Field: "voted_before", Message: "must be an RFC 3339 time or a date"})
+	}
+
+	return q, fields
+}
+
+func parseQueryTime(s string, endOfDay bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if !strings.Contains(s, "T") {
+		t, err := time.Parse(db.DateLayout, s)
+		if err == nil && endOfDay {
+			t = t.Add(24*time.Hour - time.Nanosecond)
+		}
+		return t, err
+	}
+	return time.Parse(time.RFC3339, s)
+}
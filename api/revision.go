@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etag is the entity tag of a voter revision.  It is a strong tag, the
+// JSON of one revision of a voter never changes.
+func etag(revision uint64) string {
+	return `"` + strconv.FormatUint(revision, 10) + `"`
+}
+
+// ifMatch reads the If-Match header as the revision the write depends
+// on, 0 if there is no header or it is * (the voter has to exist either
+// way).  Only a single entity tag is supported, a list is answered with
+// a 400.  A weak tag or one we never hand out cannot match, that is
+// answered with a 412 straight away.  ok is false once the request has
+// been answered.
+func ifMatch(c *gin.Context) (revision uint64, ok bool) {
+	h := strings.TrimSpace(c.GetHeader("If-Match"))
+	if h == "" || h == "*" {
+		return 0, true
+	}
+	if strings.Contains(h, ",") {
+		abortWithProblem(c, http.StatusBadRequest, "If-Match takes a single entity tag",
+			FieldError{Field: "If-Match", Message: "must be one entity tag or *"})
+		return 0, false
+	}
+	revision, err := strconv.ParseUint(strings.Trim(h, `"`), 10, 64)
+	if err != nil || revision == 0 || !strings.HasPrefix(h, `"`) || !strings.HasSuffix(h, `"`) {
+		abortWithProblem(c, http.StatusPreconditionFailed, "If-Match does not name a revision of this voter")
+		return 0, false
+	}
+	return revision, true
+}
+
+// noneMatch is true when the If-None-Match header names the current
+// revision, or is *.  The comparison is the weak one RFC 9110 asks for
+// here, W/"3" matches "3".
+func noneMatch(c *gin.Context, revision uint64) bool {
+	h := c.GetHeader("If-None-Match")
+	if h == "" {
+		return false
+	}
+	current := etag(revision)
+	for _, tag := range strings.Split(h, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
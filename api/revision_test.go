@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/patch"
+)
+
+// TestGetIfNoneMatch checks a GET naming the current revision in
+// If-None-Match is answered with a 304 and no body
+func TestGetIfNoneMatch(t *testing.T) {
+	a := newTestAPI(t)
+	a.addVoter(t, 1)
+
+	tests := []struct {
+		header string
+		status int
+	}{
+		{header: "", status: http.StatusOK},
+		{header: `"1"`, status: http.StatusNotModified},
+		{header: `W/"1"`, status: http.StatusNotModified},
+		{header: `"5", "1"`, status: http.StatusNotModified},
+		{header: `*`, status: http.StatusNotModified},
+		{header: `"2"`, status: http.StatusOK},
+	}
+	for _, test := range tests {
+		w := a.do(http.MethodGet, "/voters/1", "", "If-None-Match", test.header)
+		if w.Code != test.status {
+			t.Errorf("If-None-Match %s: expected %d, got %d", test.header, test.status, w.Code)
+			continue
+		}
+		if got := w.Header().Get("ETag"); got != `"1"` {
+			t.Errorf("If-None-Match %s: expected ETag \"1\", got %s", test.header, got)
+		}
+		if test.status == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: a 304 came with a body: %s", test.header, w.Body)
+		}
+	}
+}
+
+// TestWriteIfMatch sends every kind of write with an If-Match for an old
+// revision and expects a 412 that leaves the voter alone, and the same
+// write for the current revision to go through
+func TestWriteIfMatch(t *testing.T) {
+	writes := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		header []string
+	}{
+		{name: "PUT", method: http.MethodPut, path: "/voters",
+			body: `{"id":1,"firstname":"Bea","lastname":"Smith"}`},
+		{name: "PATCH", method: http.MethodPatch, path: "/voters/1",
+			body: `{"firstname":"Bea"}`, header: []string{"Content-Type", patch.MergePatchContentType}},
+		{name: "DELETE", method: http.MethodDelete, path: "/voters/1"},
+	}
+
+	for _, write := range writes {
+		t.Run(write.name, func(t *testing.T) {
+			a := newTestAPI(t)
+			a.addVoter(t, 1)
+			//Move the voter on to revision 2, so revision 1 is stale
+			current, err := a.store.PatchVoter(context.Background(), 1, func(voter *db.Voter) error {
+				voter.LastName = "Jones"
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, stale := range []string{`"1"`, `W/"2"`, `"99"`} {
+				w := a.do(write.method, write.path, write.body, append(write.header, "If-Match", stale)...)
+				if w.Code != http.StatusPreconditionFailed {
+					t.Errorf("If-Match %s: expected 412, got %d: %s", stale, w.Code, w.Body)
+				}
+				after, err := a.store.GetSingleVoterResource(context.Background(), 1)
+				if err != nil {
+					t.Fatalf("If-Match %s: %v", stale, err)
+				}
+				if after.Revision != current.Revision || after.FirstName != current.FirstName {
+					t.Errorf("If-Match %s: the voter was written by a failed precondition", stale)
+				}
+			}
+
+			w := a.do(write.method, write.path, write.body, append(write.header, "If-Match", `"2"`)...)
+			if w.Code != http.StatusOK {
+				t.Errorf("If-Match \"2\": expected 200, got %d: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/gin-gonic/gin"
+)
+
+const DefaultTopVoters = 10
+
+// wantsCSV decides between JSON and CSV output, ?format=csv wins over
+// an Accept: text/csv header
+func wantsCSV(c *gin.Context) bool {
+	if f := c.Query("format"); f != "" {
+		return strings.EqualFold(f, "csv")
+	}
+	return strings.Contains(c.GetHeader("Accept"), "text/csv")
+}
+
+// writeCSV sends the rows as a text/csv document, the first row is the
+// header
+func writeCSV(c *gin.Context, rows [][]string) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	if err := w.WriteAll(rows); err != nil {
+		requestLogger(c).Warn("cannot write CSV response", "error", err)
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
+
+// implementation for GET /stats/polls
+// returns the participation and turnout of every poll that has votes
+func (v *VoterAPI) GetPollStatistics(c *gin.Context) {
+
+	stats, err := v.db.PollStatistics(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if stats == nil {
+		stats = make([]db.PollStats, 0)
+	}
+
+	if wantsCSV(c) {
+		rows := [][]string{{"pollid", "participants", "votes", "turnout"}}
+		for _, s := range stats {
+			rows = append(rows, []string{
+				strconv.FormatUint(uint64(s.PollID), 10),
+				strconv.Itoa(s.Participants),
+				strconv.Itoa(s.Votes),
+				formatFloat(s.Turnout),
+			})
+		}
+		writeCSV(c, rows)
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// implementation for GET /stats/polls/:pollid
+// returns the detailed report for one poll.  ?bucket=day (the default)
+// or hour sets the histogram resolution.  As CSV only the histogram is
+// returned, one row per bucket.
+func (v *VoterAPI) GetPollReport(c *gin.Context) {
+
+	idP := c.Param("pollid")
+	id64, err := strconv.ParseInt(idP, 10, 32)
+	if err != nil {
+		abortWithInvalidParam(c, "pollid", "must be an integer")
+		return
+	}
+
+	bucket := c.Query("bucket")
+	if bucket != "" && bucket != db.BucketDay && bucket != db.BucketHour {
+		abortWithInvalidParam(c, "bucket", "must be day or hour")
+		return
+	}
+
+	report, err := v.db.PollReport(c.Request.Context(), uint(id64), bucket)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if wantsCSV(c) {
+		rows := [][]string{{"pollid", "bucket", "start", "votes"}}
+		for _, b := range report.Histogram {
+			rows = append(rows, []string{
+				strconv.FormatUint(uint64(report.PollID), 10),
+				report.Bucket,
+				b.Start.Format(time.RFC3339),
+				strconv.Itoa(b.Votes),
+			})
+		}
+		writeCSV(c, rows)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// implementation for GET /stats/voters/top
+// returns the ?n= (default 10) voters with the most votes, voters who
+// have not voted are not listed
+func (v *VoterAPI) GetTopVoters(c *gin.Context) {
+
+	n := DefaultTopVoters
+	if s := c.Query("n"); s != "" {
+		var err error
+		n, err = strconv.Atoi(s)
+		if err != nil || n < 1 || n > db.MaxPageSize {
+			abortWithInvalidParam(c, "n", "must be an integer between 1 and "+strconv.Itoa(db.MaxPageSize))
+			return
+		}
+	}
+
+	top, err := v.db.TopVoters(c.Request.Context(), n)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if wantsCSV(c) {
+		rows := [][]string{{"id", "firstname", "lastname", "votes"}}
+		for _, a := range top {
+			rows = append(rows, []string{
+				strconv.FormatUint(uint64(a.VoterID), 10),
+				a.FirstName,
+				a.LastName,
+				strconv.Itoa(a.Votes),
+			})
+		}
+		writeCSV(c, rows)
+		return
+	}
+
+	c.JSON(http.StatusOK, top)
+}
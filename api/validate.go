@@ -0,0 +1,33 @@
+package api
+
+import (
+	"context"
+	"errors"
+
+	"drexel.edu/todo/db"
+)
+
+// voterValidator holds voters to the same rules as the bulk import, see
+// db.VoterValidator.  It is made for each request so it sees the polls
+// as they are now, and only reads the ones the voter voted in.
+func (v *VoterAPI) voterValidator() *db.VoterValidator {
+	//The rules were checked when the configuration was loaded
+	rules, _ := v.cfg.VotingRules()
+	return db.NewVoterValidator(v.db, rules)
+}
+
+// validateVoter is voterValidator and Validate in one go, for handlers
+// that only have the one voter
+func (v *VoterAPI) validateVoter(ctx context.Context, voter db.Voter) error {
+	return v.voterValidator().Validate(ctx, voter)
+}
+
+// validationFields is the per field detail of a db.ValidationError, nil
+// for any other error
+func validationFields(err error) []FieldError {
+	var invalid *db.ValidationError
+	if !errors.As(err, &invalid) {
+		return nil
+	}
+	return invalid.Fields
+}
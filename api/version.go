@@ -0,0 +1,29 @@
+package api
+
+import "runtime/debug"
+
+// Version and Commit identify the build, they are reported by the health
+// checks.  Set them at build time with
+//
+//	go build -ldflags "-X drexel.edu/todo/api.Version=1.2.0 -X drexel.edu/todo/api.Commit=$(git rev-parse --short HEAD)"
+//
+// When Commit is not set it is taken from the VCS information go build
+// embeds in the binary, if there is any.
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+func init() {
+	if Commit != "" {
+		return
+	}
+	Commit = "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				Commit = s.Value
+			}
+		}
+	}
+}
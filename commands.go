@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/voterio"
+)
+
+// commandContext is canceled by SIGINT or SIGTERM, a long import or
+// migration stops after the record it is on
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+}
+
+// runImport is the import command, it adds the voters in a file to the
+// store.  Voters that already exist are skipped unless -upsert is given.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "", "Input format (json|ndjson|csv), taken from the file extension when left out")
+	upsert := fs.Bool("upsert", false, "Replace voters that already exist instead of skipping them")
+	dryRun := fs.Bool("dry-run", false, "Validate and check for duplicates without writing anything")
+	batchSize := fs.Int("batch-size", voterio.DefaultBatchSize, "Number of voters written at a time")
+	cfg, err := processCmdLineFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: import [flags] <file|->")
+	}
+
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = voterio.FormatFromPath(path)
+	}
+	if *format == "" {
+		return errors.New("cannot tell the format from the file name, use -format")
+	}
+
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	dec, err := voterio.NewDecoder(in, *format)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	rules, _ := cfg.VotingRules()
+	report, err := voterio.Import(ctx, store, dec, voterio.ImportOptions{
+		DryRun:    *dryRun,
+		Upsert:    *upsert,
+		BatchSize: *batchSize,
+		Rules:     rules,
+	})
+	for _, r := range report.Records {
+		switch r.Result {
+		case voterio.ResultRejected:
+			slog.Warn("rejected", "record", r.Record, "id", r.VoterID, "reason", r.Reason)
+		case voterio.ResultDuplicate:
+			slog.Debug("skipped, voter already exists", "record", r.Record, "id", r.VoterID)
+		}
+	}
+
+	prefix := ""
+	if *dryRun {
+		prefix = "dry run, nothing written: "
+	}
+	fmt.Printf("%screated %d, updated %d, skipped %d existing, rejected %d\n",
+		prefix, report.Created, report.Updated, report.Duplicates, report.Rejected)
+	if err != nil {
+		return err
+	}
+	if report.Rejected > 0 {
+		return fmt.Errorf("%d records were rejected", report.Rejected)
+	}
+	return nil
+}
+
+// runExport is the export command, it writes every voter to a file or
+// stdout, see db.VoterStore.ScanVoters for the order
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "", "Output format (json|ndjson|csv), taken from the -o file extension when left out, json otherwise")
+	out := fs.String("o", "-", "File to write to, - for stdout")
+	cfg, err := processCmdLineFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if *format == "" {
+		*format = voterio.FormatFromPath(*out)
+	}
+	if *format == "" {
+		*format = voterio.FormatJSON
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc, err := voterio.NewEncoder(w, *format)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	n := 0
+	err = store.ScanVoters(ctx, db.VoterQuery{}, func(voter db.Voter) error {
+		n++
+		return enc.Encode(voter)
+	})
+	if err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	slog.Info("exported", "voters", n, "format", *format)
+	return nil
+}
+
+// Names the seed command builds its voters from
+var (
+	seedFirstNames = []string{"John", "Jane", "Bob", "Alice", "Maria", "Wei", "Ahmed", "Olga", "Kofi", "Priya",
+		"Liam", "Emma", "Noah", "Ava", "Mateo", "Sofia", "Yuki", "Fatima", "Lucas", "Chloe"}
+	seedLastNames = []string{"Doe", "Schmoe", "Ross", "Smith", "Garcia", "Chen", "Khan", "Ivanova", "Mensah", "Patel",
+		"Johnson", "Williams", "Brown", "Jones", "Miller", "Davis", "Lopez", "Tanaka", "Silva", "Martin"}
+)
+
+// runSeed is the seed command, it creates open polls and voters who
+// voted in a random selection of them, for demos and load tests
+func runSeed(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	voters := fs.Int("voters", 1000, "Number of voters to create")
+	polls := fs.Int("polls", 5, "Number of polls to create")
+	firstVoter := fs.Uint("first-voter", 1, "Id of the first voter, the rest are numbered from there")
+	firstPoll := fs.Uint("first-poll", 1, "Id of the first poll, the rest are numbered from there")
+	days := fs.Int("days", 30, "Votes are spread over this many days up to now")
+	seed := fs.Int64("seed", 0, "Random seed, the same seed creates the same data, 0 picks one")
+	cfg, err := processCmdLineFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *voters < 0 || *polls < 0 || *days <= 0 {
+		return errors.New("-voters and -polls cannot be negative and -days has to be positive")
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	rnd := rand.New(rand.NewSource(*seed))
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	pollIds := make([]uint, 0, *polls)
+	for i := 0; i < *polls; i++ {
+		poll := db.Poll{
+			PollID:  *firstPoll + uint(i),
+			Title:   fmt.Sprintf("Seeded poll %d", *firstPoll+uint(i)),
+			Options: []string{"Yes", "No"},
+			Status:  db.PollOpen,
+		}
+		err := store.AddPollResource(ctx, poll)
+		if err != nil && !errors.Is(err, db.ErrPollExists) {
+			return err
+		}
+		pollIds = append(pollIds, poll.PollID)
+	}
+
+	now := time.Now().UTC()
+	span := int64(*days) * int64(24*time.Hour/time.Second)
+	created, skipped := 0, 0
+	batch := make([]db.Voter, 0, voterio.DefaultBatchSize)
+	flush := func() error {
+		results, err := store.AddVoters(ctx, batch, false)
+		if err != nil {
+			return err
+		}
+		for _, err := range results {
+			if err == nil {
+				created++
+			} else {
+				skipped++
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for i := 0; i < *voters; i++ {
+		voter := db.Voter{
+			VoterId:     *firstVoter + uint(i),
+			FirstName:   seedFirstNames[rnd.Intn(len(seedFirstNames))],
+			LastName:    seedLastNames[rnd.Intn(len(seedLastNames))],
+			VoteHistory: []db.VoterPoll{},
+		}
+		for _, pollId := range pollIds {
+			//roughly 60% turnout in every poll
+			if rnd.Intn(10) < 6 {
+				date := now.Add(-time.Duration(rnd.Int63n(span)) * time.Second)
+				voter.VoteHistory = append(voter.VoteHistory, db.VoterPoll{PollID: pollId, VoteDate: date})
+			}
+		}
+
+		batch = append(batch, voter)
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("created %d polls and %d voters, skipped %d existing voters (seed %d)\n",
+		len(pollIds), created, skipped, *seed)
+	return nil
+}
+
+// runMigrate is the migrate command, see db.Migrations
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Report what would change without writing anything")
+	cfg, err := processCmdLineFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	results, err := db.Migrate(ctx, store, *dryRun)
+	for _, r := range results {
+		verb := "changed"
+		if *dryRun {
+			verb = "would change"
+		}
+		fmt.Printf("%d %s: %s %d\n", r.Version, r.Description, verb, r.Changed)
+	}
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		fmt.Printf("already at schema version %d\n", db.SchemaVersion())
+	}
+	return nil
+}
+
+// runReindex is the reindex command, see db.VoterStore.RebuildPollIndex
+func runReindex(args []string) error {
+	cfg, err := processCmdLineFlags(flag.NewFlagSet("reindex", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	if err := store.RebuildPollIndex(ctx); err != nil {
+		return err
+	}
+	slog.Info("poll index rebuilt")
+	return nil
+}
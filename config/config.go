@@ -0,0 +1,325 @@
+// Package config holds every setting of the voter API in one typed
+// struct.  Settings are resolved in this order, each source overriding
+// the ones before it:
+//
+//  1. the defaults from Default
+//  2. the config file, given with -config or $VOTER_CONFIG, YAML, JSON
+//     or TOML picked by its extension, see config.example.yaml
+//  3. environment variables, see envVars
+//  4. command line flags
+//
+// The result is checked by Validate before anything is started.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/logging"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const ConfigEnv = "VOTER_CONFIG"
+
+type Config struct {
+	Server ServerConfig `yaml:"server"`
+	Store  StoreConfig  `yaml:"store"`
+	Voting VotingConfig `yaml:"voting"`
+	Log    LogConfig    `yaml:"log"`
+}
+
+// ServerConfig is the HTTP listener and its limits
+type ServerConfig struct {
+	Host string `yaml:"host"`
+	Port uint   `yaml:"port"`
+
+	ReadTimeout       time.Duration `yaml:"readtimeout"`
+	ReadHeaderTimeout time.Duration `yaml:"readheadertimeout"`
+	//WriteTimeout covers the whole handler, it has to be longer than
+	//the store's scan timeout or long listings never get to 504
+	WriteTimeout  time.Duration `yaml:"writetimeout"`
+	IdleTimeout   time.Duration `yaml:"idletimeout"`
+	ShutdownGrace time.Duration `yaml:"shutdowngrace"`
+
+	MaxHeaderBytes int   `yaml:"maxheaderbytes"`
+	MaxBodyBytes   int64 `yaml:"maxbodybytes"`
+	//MaxBatchBytes replaces MaxBodyBytes for the bulk endpoints, they
+	//take a whole voter roll in one body
+	MaxBatchBytes int64 `yaml:"maxbatchbytes"`
+
+	//IdempotencyTTL is how long the answer to a POST with an
+	//Idempotency-Key is kept for replay, 0 ignores the header
+	IdempotencyTTL time.Duration `yaml:"idempotencyttl"`
+}
+
+// StoreConfig picks the storage backend, only the section for the
+// chosen backend is used
+type StoreConfig struct {
+	Backend string      `yaml:"backend"`
+	DataDir string      `yaml:"datadir"`
+	Redis   RedisConfig `yaml:"redis"`
+}
+
+type RedisConfig struct {
+	Addr      string `yaml:"addr"`
+	Password  string `yaml:"password"`
+	DB        int    `yaml:"db"`
+	TLS       bool   `yaml:"tls"`
+	PoolSize  int    `yaml:"poolsize"`
+	KeyPrefix string `yaml:"keyprefix"`
+
+	ReadTimeout  time.Duration `yaml:"readtimeout"`
+	WriteTimeout time.Duration `yaml:"writetimeout"`
+	ScanTimeout  time.Duration `yaml:"scantimeout"`
+}
+
+// VotingConfig is parsed with db.ParseVotingRules
+type VotingConfig struct {
+	//Default is the policy for polls without their own
+	Default string `yaml:"default"`
+	//Polls overrides it per poll, e.g. 59231=revote,12345=multiple
+	Polls string `yaml:"polls"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// Default returns the settings used when nothing else is configured
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Host:              "0.0.0.0",
+			Port:              1080,
+			ReadTimeout:       10 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			ShutdownGrace:     10 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+			MaxBodyBytes:      1 << 20,
+			MaxBatchBytes:     64 << 20,
+			IdempotencyTTL:    24 * time.Hour,
+		},
+		Store: StoreConfig{
+			Backend: db.DefaultStore,
+			DataDir: db.FileDefaultLocation,
+			Redis: RedisConfig{
+				Addr:         db.RedisDefaultLocation,
+				ReadTimeout:  db.DefaultTimeouts.Read,
+				WriteTimeout: db.DefaultTimeouts.Write,
+				ScanTimeout:  db.DefaultTimeouts.Scan,
+			},
+		},
+		Voting: VotingConfig{
+			Default: string(db.DefaultVotePolicy),
+		},
+		Log: LogConfig{
+			Level:  logging.DefaultLevel,
+			Format: logging.DefaultFormat,
+		},
+	}
+}
+
+// envVars maps each environment variable to the flag it stands in for,
+// setting the variable is the same as passing the flag
+var envVars = []struct{ env, flag string }{
+	{"VOTER_HOST", "h"},
+	{"VOTER_PORT", "p"},
+	{"VOTER_HTTP_READ_TIMEOUT", "http-read-timeout"},
+	{"VOTER_HTTP_HEADER_TIMEOUT", "http-header-timeout"},
+	{"VOTER_HTTP_WRITE_TIMEOUT", "http-write-timeout"},
+	{"VOTER_HTTP_IDLE_TIMEOUT", "http-idle-timeout"},
+	{"VOTER_SHUTDOWN_GRACE", "shutdown-grace"},
+	{"VOTER_MAX_HEADER_BYTES", "max-header-bytes"},
+	{"VOTER_MAX_BODY_BYTES", "max-body-bytes"},
+	{"VOTER_MAX_BATCH_BYTES", "max-batch-bytes"},
+	{"VOTER_IDEMPOTENCY_TTL", "idempotency-ttl"},
+
+	{"VOTER_STORE", "s"},
+	{"VOTER_DATA_DIR", "data-dir"},
+	{"REDIS_URL", "redis-addr"},
+	{"REDIS_PASSWORD", "redis-password"},
+	{"REDIS_DB", "redis-db"},
+	{"REDIS_TLS", "redis-tls"},
+	{"REDIS_POOL_SIZE", "redis-pool-size"},
+	{"REDIS_KEY_PREFIX", "redis-key-prefix"},
+	{"VOTER_READ_TIMEOUT", "read-timeout"},
+	{"VOTER_WRITE_TIMEOUT", "write-timeout"},
+	{"VOTER_SCAN_TIMEOUT", "scan-timeout"},
+
+	{"VOTER_VOTE_POLICY", "vote-policy"},
+	{"VOTER_POLL_POLICIES", "poll-policies"},
+
+	{"VOTER_LOG_LEVEL", "log-level"},
+	{"VOTER_LOG_FORMAT", "log-format"},
+}
+
+// bindFlags defines a flag for every setting on fs, the current values
+// of c (defaults plus the config file) are the flag defaults
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	//Note some networking lingo, some frameworks start the server on localhost
+	//this is a local-only interface and is fine for testing but its not accessible
+	//from other machines.  To make the server accessible from other machines, we
+	//need to listen on an interface, that could be an IP address, but modern
+	//cloud servers may have multiple network interfaces for scale.  With TCP/IP
+	//the address 0.0.0.0 instructs the network stack to listen on all interfaces
+	fs.StringVar(&c.Server.Host, "h", c.Server.Host, "Listen on all interfaces")
+	fs.UintVar(&c.Server.Port, "p", c.Server.Port, "Default Port")
+	fs.DurationVar(&c.Server.ReadTimeout, "http-read-timeout", c.Server.ReadTimeout, "Time allowed to read a whole request")
+	fs.DurationVar(&c.Server.ReadHeaderTimeout, "http-header-timeout", c.Server.ReadHeaderTimeout, "Time allowed to read the request headers")
+	fs.DurationVar(&c.Server.WriteTimeout, "http-write-timeout", c.Server.WriteTimeout, "Time allowed to handle a request and write the response")
+	fs.DurationVar(&c.Server.IdleTimeout, "http-idle-timeout", c.Server.IdleTimeout, "How long an idle keep-alive connection is kept open")
+	fs.DurationVar(&c.Server.ShutdownGrace, "shutdown-grace", c.Server.ShutdownGrace, "How long in-flight requests get to finish on SIGINT/SIGTERM")
+	fs.IntVar(&c.Server.MaxHeaderBytes, "max-header-bytes", c.Server.MaxHeaderBytes, "Maximum size of the request headers")
+	fs.Int64Var(&c.Server.MaxBodyBytes, "max-body-bytes", c.Server.MaxBodyBytes, "Maximum size of a request body, larger bodies get a 413")
+	fs.Int64Var(&c.Server.MaxBatchBytes, "max-batch-bytes", c.Server.MaxBatchBytes, "Maximum size of a bulk import body")
+	fs.DurationVar(&c.Server.IdempotencyTTL, "idempotency-ttl", c.Server.IdempotencyTTL, "How long answers to POSTs with an Idempotency-Key are replayed, 0 ignores the header")
+
+	fs.StringVar(&c.Store.Backend, "s", c.Store.Backend, "Storage backend (redis|memory|file)")
+	fs.StringVar(&c.Store.DataDir, "data-dir", c.Store.DataDir, "Directory the file backend keeps its data in")
+	fs.StringVar(&c.Store.Redis.Addr, "redis-addr", c.Store.Redis.Addr, "Redis host:port or redis:// / rediss:// URL")
+	fs.StringVar(&c.Store.Redis.Password, "redis-password", c.Store.Redis.Password, "Redis password, prefer $REDIS_PASSWORD so it does not show up in ps")
+	fs.IntVar(&c.Store.Redis.DB, "redis-db", c.Store.Redis.DB, "Redis database number")
+	fs.BoolVar(&c.Store.Redis.TLS, "redis-tls", c.Store.Redis.TLS, "Connect to redis over TLS")
+	fs.IntVar(&c.Store.Redis.PoolSize, "redis-pool-size", c.Store.Redis.PoolSize, "Maximum redis connections, 0 for 10 per CPU")
+	fs.StringVar(&c.Store.Redis.KeyPrefix, "redis-key-prefix", c.Store.Redis.KeyPrefix, "Prefix for every redis key, to share one redis between deployments")
+	//Deadlines for the redis operations, a request that runs out of time
+	//is answered with a 504.  0 disables the deadline.
+	fs.DurationVar(&c.Store.Redis.ReadTimeout, "read-timeout", c.Store.Redis.ReadTimeout, "Deadline for single voter and poll lookups")
+	fs.DurationVar(&c.Store.Redis.WriteTimeout, "write-timeout", c.Store.Redis.WriteTimeout, "Deadline for writes, including retries")
+	fs.DurationVar(&c.Store.Redis.ScanTimeout, "scan-timeout", c.Store.Redis.ScanTimeout, "Deadline for listings, statistics and bulk deletes")
+
+	//What happens when somebody votes twice in the same poll, see db.VotePolicy
+	fs.StringVar(&c.Voting.Default, "vote-policy", c.Voting.Default, "Default vote policy (single|revote|multiple)")
+	fs.StringVar(&c.Voting.Polls, "poll-policies", c.Voting.Polls, "Per poll vote policies, e.g. 59231=revote,12345=multiple")
+
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "Log level (debug|info|warn|error), debug also logs every redis command")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "Log format (json|text)")
+}
+
+// Load resolves the configuration from the defaults, the config file,
+// the environment and args, in that order.  The flags are defined on fs
+// (the caller may have added flags of its own) and args are parsed with
+// it.
+func Load(fs *flag.FlagSet, args []string) (Config, error) {
+	cfg := Default()
+
+	path := configPath(args)
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	//-config is only declared so it shows up in the usage and parses, it
+	//was already read by configPath
+	fs.String("config", path, "YAML, JSON or TOML config file, defaults to $"+ConfigEnv)
+	cfg.bindFlags(fs)
+
+	for _, e := range envVars {
+		v, ok := os.LookupEnv(e.env)
+		if !ok || v == "" {
+			continue
+		}
+		if err := fs.Set(e.flag, v); err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", e.env, err)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// configPath finds -config on the command line, or falls back to the
+// environment.  The file has to be read before the flags are parsed, or
+// it would override them.
+func configPath(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if v, ok := strings.CutPrefix(name, "config="); ok {
+			return v
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv(ConfigEnv)
+}
+
+// loadFile merges the file into c, keys the file leaves out keep their
+// current value.  Unknown keys are an error so typos do not go unnoticed,
+// and so is an extension that is not one of the formats below.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".yaml", ".yml", ".json":
+	case ".toml":
+		if data, err = tomlToYAML(data); err != nil {
+			return fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("config file %s: unknown extension %q, use .yaml, .yml, .json or .toml", path, ext)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		//The line numbers of a TOML file are those of the YAML it was
+		//turned into, they would only point the reader at the wrong line
+		var typeErr *yaml.TypeError
+		if ext == ".toml" && errors.As(err, &typeErr) {
+			msgs := make([]string, len(typeErr.Errors))
+			for i, msg := range typeErr.Errors {
+				msgs[i] = tomlLine.ReplaceAllString(msg, "")
+			}
+			err = errors.New(strings.Join(msgs, "; "))
+		}
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+var tomlLine = regexp.MustCompile(`^line \d+: `)
+
+// tomlToYAML turns a TOML document into the same settings written as
+// YAML, so both go through one decoder and get the same durations and
+// the same checks for unknown keys
+func tomlToYAML(data []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc) == 0 {
+		return nil, nil
+	}
+	return yaml.Marshal(doc)
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLoadFileFormats loads the same settings from each supported format
+// and expects the same result, durations included
+func TestLoadFileFormats(t *testing.T) {
+	files := map[string]string{
+		"voters.yaml": "server:\n  port: 2080\n  writetimeout: 45s\nstore:\n  redis:\n    db: 3\n",
+		"voters.json": `{"server": {"port": 2080, "writetimeout": "45s"}, "store": {"redis": {"db": 3}}}`,
+		"voters.toml": "[server]\nport = 2080\nwritetimeout = \"45s\"\n\n[store.redis]\ndb = 3\n",
+	}
+
+	want := Default()
+	want.Server.Port = 2080
+	want.Server.WriteTimeout = 45 * time.Second
+	want.Store.Redis.DB = 3
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		got := Default()
+		if err := got.loadFile(path); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: loaded %+v, expected %+v", name, got, want)
+		}
+	}
+}
+
+// TestLoadFileRejects expects unknown keys and unknown extensions to stop
+// the load, with the file named in the error
+func TestLoadFileRejects(t *testing.T) {
+	files := map[string]string{
+		"voters.ini":  "[server]\nport = 2080\n",
+		"voters.toml": "[server]\nprot = 2080\n",
+		"voters.yaml": "server:\n  prot: 2080\n",
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		cfg := Default()
+		err := cfg.loadFile(path)
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+			continue
+		}
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("%s: error does not name the file: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/logging"
+)
+
+// Validate checks the settings make sense together, every problem is
+// reported, not just the first one
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	s := c.Server
+	check(s.Port > 0 && s.Port <= 65535, "server.port %d is not a valid port", s.Port)
+	check(s.ReadTimeout >= 0 && s.ReadHeaderTimeout >= 0 && s.WriteTimeout >= 0 &&
+		s.IdleTimeout >= 0 && s.ShutdownGrace >= 0, "server timeouts cannot be negative")
+	check(s.MaxHeaderBytes >= 0 && s.MaxBodyBytes >= 0 && s.MaxBatchBytes >= 0, "server size limits cannot be negative")
+	check(s.IdempotencyTTL >= 0, "server.idempotencyttl cannot be negative")
+
+	switch c.Store.Backend {
+	case db.StoreRedis:
+		r := c.Store.Redis
+		check(r.Addr != "", "store.redis.addr is required")
+		check(r.DB >= 0, "store.redis.db cannot be negative")
+		check(r.PoolSize >= 0, "store.redis.poolsize cannot be negative")
+		check(r.ReadTimeout >= 0 && r.WriteTimeout >= 0 && r.ScanTimeout >= 0,
+			"store.redis timeouts cannot be negative")
+		check(s.WriteTimeout == 0 || r.ScanTimeout == 0 || s.WriteTimeout > r.ScanTimeout,
+			"server.writetimeout (%s) must be longer than store.redis.scantimeout (%s)", s.WriteTimeout, r.ScanTimeout)
+	case db.StoreFile:
+		check(c.Store.DataDir != "", "store.datadir is required for the file store")
+	case db.StoreMemory:
+	default:
+		errs = append(errs, fmt.Errorf("unknown store.backend %q", c.Store.Backend))
+	}
+
+	if _, err := c.VotingRules(); err != nil {
+		errs = append(errs, fmt.Errorf("voting: %w", err))
+	}
+	if _, err := logging.New(io.Discard, c.Log.Level, c.Log.Format); err != nil {
+		errs = append(errs, fmt.Errorf("log: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// VotingRules parses the voting section
+func (c Config) VotingRules() (db.VotingRules, error) {
+	return db.ParseVotingRules(c.Voting.Default, c.Voting.Polls)
+}
+
+// StoreOptions is the store section in the shape db.NewVoterStore wants
+func (c Config) StoreOptions() db.StoreOptions {
+	r := c.Store.Redis
+	return db.StoreOptions{
+		Backend: c.Store.Backend,
+		DataDir: c.Store.DataDir,
+		Redis: db.RedisOptions{
+			Addr:      r.Addr,
+			Password:  r.Password,
+			DB:        r.DB,
+			TLS:       r.TLS,
+			PoolSize:  r.PoolSize,
+			KeyPrefix: r.KeyPrefix,
+			Timeouts: db.Timeouts{
+				Read:  r.ReadTimeout,
+				Write: r.WriteTimeout,
+				Scan:  r.ScanTimeout,
+			},
+		},
+	}
+}
+
+// Addr is the host:port the server listens on
+func (s ServerConfig) Addr() string {
+	return fmt.Sprintf("%s:%d", s.Host, s.Port)
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// These are the errors the storage backends report, callers should
+// compare against them with errors.Is since the backends are free to
+// wrap them with more detail
+var (
+	ErrVoterNotFound      = errors.New("voter does not exist")
+	ErrVoterExists        = errors.New("voter already exists")
+	ErrPollNotFound       = errors.New("poll does not exist")
+	ErrAlreadyVoted       = errors.New("voter already voted in this poll")
+	ErrPollExists         = errors.New("poll already exists")
+	ErrPollNotOpen        = errors.New("poll is not open for voting")
+	ErrInvalidPoll        = errors.New("invalid poll")
+	ErrStorageUnavailable = errors.New("storage unavailable")
+
+	//ErrTimeout is returned when the storage did not answer within the
+	//operation's deadline, see Timeouts.  ErrCanceled when the caller
+	//gave up first.
+	ErrTimeout  = errors.New("storage operation timed out")
+	ErrCanceled = errors.New("storage operation canceled")
+
+	//ErrInvalidQuery is returned when a VoterQuery cannot be run, for
+	//example the sort field is unknown or the cursor is corrupt
+	ErrInvalidQuery = errors.New("invalid voter query")
+
+	//ErrInvalidVoter is returned for a voter that breaks the rules in
+	//validate.go, the error is a *ValidationError with the details
+	ErrInvalidVoter = errors.New("invalid voter")
+
+	//ErrRevisionMismatch is returned when a write was made conditional
+	//on a revision of the voter that is no longer the stored one
+	ErrRevisionMismatch = errors.New("voter has been changed since that revision")
+
+	//ErrSchemaTooNew is returned by Migrate when the data was written
+	//by a newer version than this one
+	ErrSchemaTooNew = errors.New("stored data is newer than this version understands")
+)
+
+// storageError marks err as a failure of the underlying storage rather
+// than a problem with the request itself.  Running out of time is told
+// apart from the storage being down, go-redis reports a passed deadline
+// either as the context error or as a network timeout.
+func storageError(err error) error {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("%w: %w", ErrCanceled, err)
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	default:
+		return fmt.Errorf("%w: %w", ErrStorageUnavailable, err)
+	}
+}
+
+// isDomainError reports whether err is one of the errors above, as
+// opposed to something unexpected coming back from a storage driver
+func isDomainError(err error) bool {
+	return errors.Is(err, ErrVoterNotFound) ||
+		errors.Is(err, ErrVoterExists) ||
+		errors.Is(err, ErrPollNotFound) ||
+		errors.Is(err, ErrAlreadyVoted) ||
+		errors.Is(err, ErrPollExists) ||
+		errors.Is(err, ErrPollNotOpen) ||
+		errors.Is(err, ErrInvalidPoll) ||
+		errors.Is(err, ErrStorageUnavailable) ||
+		errors.Is(err, ErrTimeout) ||
+		errors.Is(err, ErrCanceled) ||
+		errors.Is(err, ErrInvalidQuery) ||
+		errors.Is(err, ErrInvalidVoter) ||
+		errors.Is(err, ErrRevisionMismatch)
+}
@@ -0,0 +1,461 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	FileDefaultLocation = "./data"
+	FileVotersName      = "voters.json"
+	FilePollsName       = "polls.json"
+	FileMetaName        = "meta.json"
+	FileIdempotencyName = "idempotency.json"
+)
+
+// FileVoterList is a single node VoterStore that keeps the voters and
+// the polls in JSON files.  All reads are served from memory.  Every
+// write rewrites its file with a write-to-temp-then-rename, so the file
+// on disk is always either the old or the new snapshot, never a partial
+// one.  A write that cannot be saved is undone in memory as well.
+type FileVoterList struct {
+	//serializes the mutate+persist sequence so snapshots always land
+	//on disk in the same order the changes were made
+	mu        sync.Mutex
+	path      string
+	pollsPath string
+	metaPath  string
+	idemPath  string
+
+	mem *MemoryVoterList
+}
+
+// NewWithFileInstance opens (or creates) the voter file in dir and loads
+// it into memory, an empty dir means FileDefaultLocation
+func NewWithFileInstance(dir string) (*FileVoterList, error) {
+	if dir == "" {
+		dir = FileDefaultLocation
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	lst := &FileVoterList{
+		path:      filepath.Join(dir, FileVotersName),
+		pollsPath: filepath.Join(dir, FilePollsName),
+		metaPath:  filepath.Join(dir, FileMetaName),
+		idemPath:  filepath.Join(dir, FileIdempotencyName),
+		mem:       NewMemoryVoterList(),
+	}
+
+	if err := lst.load(); err != nil {
+		return nil, err
+	}
+
+	return lst, nil
+}
+
+// load reads the snapshots from disk.  A temp file left behind by a
+// crash in the middle of a save is never the source of truth (the rename
+// did not happen) so it is simply removed.
+func (lst *FileVoterList) load() error {
+	var voters []Voter
+	if err := loadJSON(lst.path, &voters); err != nil {
+		return err
+	}
+	for _, voter := range voters {
+		lst.mem.put(nil, voter)
+	}
+
+	var polls []Poll
+	if err := loadJSON(lst.pollsPath, &polls); err != nil {
+		return err
+	}
+	for _, poll := range polls {
+		lst.mem.polls[poll.PollID] = poll
+	}
+
+	//Only answered requests are saved, and only until they expire
+	var keys map[string]IdempotencyRecord
+	if err := loadJSON(lst.idemPath, &keys); err != nil {
+		return err
+	}
+	now := time.Now()
+	for key, record := range keys {
+		if record.Done() && now.Before(record.Expires) {
+			lst.mem.idempotency[key] = record
+		}
+	}
+
+	//Files written before meta.json existed are at version 0, a new
+	//directory is stamped with the current version straight away
+	meta := fileMeta{SchemaVersion: -1}
+	if err := loadJSON(lst.metaPath, &meta); err != nil {
+		return err
+	}
+	if meta.LastVoterID > lst.mem.lastVoterID {
+		lst.mem.lastVoterID = meta.LastVoterID
+	}
+	switch {
+	case meta.SchemaVersion >= 0:
+		lst.mem.schemaVersion = meta.SchemaVersion
+	case len(voters) > 0 || len(polls) > 0:
+		lst.mem.schemaVersion = 0
+	default:
+		return lst.saveMeta(context.Background())
+	}
+
+	return nil
+}
+
+// fileMeta is what meta.json holds, everything about the data that is
+// not a voter or a poll
+type fileMeta struct {
+	SchemaVersion int `json:"schemaversion"`
+	//LastVoterID is only needed once the voter with the highest id has
+	//been deleted, otherwise it is the same as the highest id loaded
+	LastVoterID uint `json:"lastvoterid"`
+}
+
+func loadJSON(path string, v any) error {
+	os.Remove(path + ".tmp")
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// save writes the current voters to disk
+func (lst *FileVoterList) save(ctx context.Context) error {
+	voters, err := lst.mem.GetAllVoters(ctx)
+	if err != nil {
+		return err
+	}
+	if voters == nil {
+		voters = make([]Voter, 0)
+	}
+	return saveJSON(lst.path, voters)
+}
+
+// savePolls writes the current polls to disk
+func (lst *FileVoterList) savePolls(ctx context.Context) error {
+	polls, err := lst.mem.GetAllPolls(ctx)
+	if err != nil {
+		return err
+	}
+	if polls == nil {
+		polls = make([]Poll, 0)
+	}
+	return saveJSON(lst.pollsPath, polls)
+}
+
+// saveMeta writes meta.json
+func (lst *FileVoterList) saveMeta(ctx context.Context) error {
+	lst.mem.mu.RLock()
+	meta := fileMeta{
+		SchemaVersion: lst.mem.schemaVersion,
+		LastVoterID:   lst.mem.lastVoterID,
+	}
+	lst.mem.mu.RUnlock()
+	return saveJSON(lst.metaPath, meta)
+}
+
+// saveIdempotency writes the answered idempotency keys that have not
+// expired, a reservation for a request that is still running is not
+// worth keeping across a restart
+func (lst *FileVoterList) saveIdempotency(ctx context.Context) error {
+	lst.mem.mu.RLock()
+	now := time.Now()
+	keys := make(map[string]IdempotencyRecord, len(lst.mem.idempotency))
+	for key, record := range lst.mem.idempotency {
+		if record.Done() && now.Before(record.Expires) {
+			keys[key] = record
+		}
+	}
+	lst.mem.mu.RUnlock()
+	return saveJSON(lst.idemPath, keys)
+}
+
+// saveWithMeta writes the voters and meta.json, for the changes that
+// can move the highest id in use
+func (lst *FileVoterList) saveWithMeta(ctx context.Context) error {
+	if err := lst.save(ctx); err != nil {
+		return err
+	}
+	return lst.saveMeta(ctx)
+}
+
+// saveJSON writes v next to the real file, syncs it, and then renames
+// it over the top, rename is atomic on POSIX filesystems
+func saveJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	//The rename itself lives in the directory entry, sync that too so
+	//it survives a power loss
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// mutate runs op against the in memory copy and persists the voters
+func (lst *FileVoterList) mutate(ctx context.Context, op func() error) error {
+	return lst.mutateWith(ctx, op, lst.save)
+}
+
+// mutatePolls runs op against the in memory copy and persists the polls
+func (lst *FileVoterList) mutatePolls(ctx context.Context, op func() error) error {
+	return lst.mutateWith(ctx, op, lst.savePolls)
+}
+
+// mutateWith runs op against the in memory copy and persists it with
+// save.  If save fails the records op changed go back to how they were,
+// the caller gets an error and nothing is served that is not on disk.
+// Only those records are kept to undo op, but save still writes out the
+// whole file, see the file backend in config.example.yaml.
+func (lst *FileVoterList) mutateWith(ctx context.Context, op func() error, save func(context.Context) error) error {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	lst.mem.track()
+	if err := op(); err != nil {
+		lst.mem.rollback()
+		return err
+	}
+	if err := save(ctx); err != nil {
+		lst.mem.rollback()
+		return storageError(err)
+	}
+	lst.mem.commit()
+	return nil
+}
+
+// SetVotingRules passes the rules on to the in memory copy, which is
+// where votes are actually recorded
+func (lst *FileVoterList) SetVotingRules(rules VotingRules) {
+	lst.mem.SetVotingRules(rules)
+}
+
+// Close waits for a save in progress to land, every change is already
+// on disk once its call returns so there is nothing to flush
+func (lst *FileVoterList) Close() error {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+	return nil
+}
+
+// Ping checks the data directory is still there and writable, saves
+// would fail otherwise
+func (lst *FileVoterList) Ping(ctx context.Context) error {
+	f, err := os.CreateTemp(filepath.Dir(lst.path), ".ping-*")
+	if err != nil {
+		return storageError(err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
+
+func (lst *FileVoterList) AddVoter(ctx context.Context, voter Voter) (Voter, error) {
+	var added Voter
+	err := lst.mutate(ctx, func() error {
+		var err error
+		added, err = lst.mem.AddVoter(ctx, voter)
+		return err
+	})
+	return added, err
+}
+
+// AddVoters saves the file once for the whole batch
+func (lst *FileVoterList) AddVoters(ctx context.Context, voters []Voter, dryRun bool) ([]error, error) {
+	if dryRun {
+		return lst.mem.AddVoters(ctx, voters, true)
+	}
+
+	var results []error
+	err := lst.mutate(ctx, func() error {
+		var err error
+		results, err = lst.mem.AddVoters(ctx, voters, false)
+		return err
+	})
+	return results, err
+}
+
+func (lst *FileVoterList) CreateVoter(ctx context.Context, voter Voter) (Voter, error) {
+	var created Voter
+	err := lst.mutateWith(ctx, func() error {
+		var err error
+		created, err = lst.mem.CreateVoter(ctx, voter)
+		return err
+	}, lst.saveWithMeta)
+	return created, err
+}
+
+func (lst *FileVoterList) UpdateVoter(ctx context.Context, voter Voter) error {
+	return lst.mutate(ctx, func() error { return lst.mem.UpdateVoter(ctx, voter) })
+}
+
+func (lst *FileVoterList) PatchVoter(ctx context.Context, id uint, fn func(voter *Voter) error) (Voter, error) {
+	var voter Voter
+	err := lst.mutate(ctx, func() error {
+		var err error
+		voter, err = lst.mem.PatchVoter(ctx, id, fn)
+		return err
+	})
+	return voter, err
+}
+
+func (lst *FileVoterList) DeleteVoter(ctx context.Context, id uint, revision uint64) error {
+	return lst.mutateWith(ctx, func() error { return lst.mem.DeleteVoter(ctx, id, revision) }, lst.saveWithMeta)
+}
+
+func (lst *FileVoterList) DeleteAll(ctx context.Context) error {
+	return lst.mutateWith(ctx, func() error { return lst.mem.DeleteAll(ctx) }, lst.saveWithMeta)
+}
+
+func (lst *FileVoterList) GetSingleVoterResource(ctx context.Context, id uint) (Voter, error) {
+	return lst.mem.GetSingleVoterResource(ctx, id)
+}
+
+func (lst *FileVoterList) GetAllVoters(ctx context.Context) ([]Voter, error) {
+	return lst.mem.GetAllVoters(ctx)
+}
+
+func (lst *FileVoterList) ListVoters(ctx context.Context, q VoterQuery) (VoterPage, error) {
+	return lst.mem.ListVoters(ctx, q)
+}
+
+func (lst *FileVoterList) ScanVoters(ctx context.Context, q VoterQuery, fn func(voter Voter) error) error {
+	return lst.mem.ScanVoters(ctx, q, fn)
+}
+
+func (lst *FileVoterList) GetVoterHistory(ctx context.Context, id uint) ([]VoterPoll, error) {
+	return lst.mem.GetVoterHistory(ctx, id)
+}
+
+func (lst *FileVoterList) GetVoterPollData(ctx context.Context, voterId uint, pollId uint) (*VoterPoll, error) {
+	return lst.mem.GetVoterPollData(ctx, voterId, pollId)
+}
+
+func (lst *FileVoterList) AddVoterPollData(ctx context.Context, voterId uint, pollId uint) error {
+	return lst.mutate(ctx, func() error { return lst.mem.AddVoterPollData(ctx, voterId, pollId) })
+}
+
+func (lst *FileVoterList) DeletePoll(ctx context.Context, voterId uint, pollId uint) error {
+	return lst.mutate(ctx, func() error { return lst.mem.DeletePoll(ctx, voterId, pollId) })
+}
+
+func (lst *FileVoterList) GetPollVoters(ctx context.Context, pollId uint, limit int, cursor string) (VoterPage, error) {
+	return lst.mem.GetPollVoters(ctx, pollId, limit, cursor)
+}
+
+func (lst *FileVoterList) CountPollVoters(ctx context.Context, pollId uint) (int, error) {
+	return lst.mem.CountPollVoters(ctx, pollId)
+}
+
+// RebuildPollIndex rebuilds the in memory index, the index itself is
+// never written to disk, it is rebuilt from the voters on every start
+func (lst *FileVoterList) RebuildPollIndex(ctx context.Context) error {
+	return lst.mem.RebuildPollIndex(ctx)
+}
+
+func (lst *FileVoterList) SchemaVersion(ctx context.Context) (int, error) {
+	return lst.mem.SchemaVersion(ctx)
+}
+
+func (lst *FileVoterList) SetSchemaVersion(ctx context.Context, version int) error {
+	return lst.mutateWith(ctx, func() error { return lst.mem.SetSchemaVersion(ctx, version) }, lst.saveMeta)
+}
+
+func (lst *FileVoterList) PollStatistics(ctx context.Context) ([]PollStats, error) {
+	return lst.mem.PollStatistics(ctx)
+}
+
+func (lst *FileVoterList) PollReport(ctx context.Context, pollId uint, bucket string) (PollReport, error) {
+	return lst.mem.PollReport(ctx, pollId, bucket)
+}
+
+func (lst *FileVoterList) TopVoters(ctx context.Context, n int) ([]VoterActivity, error) {
+	return lst.mem.TopVoters(ctx, n)
+}
+
+func (lst *FileVoterList) VoterCounts(ctx context.Context) (int, int, error) {
+	return lst.mem.VoterCounts(ctx)
+}
+
+func (lst *FileVoterList) AddPollResource(ctx context.Context, poll Poll) error {
+	return lst.mutatePolls(ctx, func() error { return lst.mem.AddPollResource(ctx, poll) })
+}
+
+func (lst *FileVoterList) UpdatePollResource(ctx context.Context, poll Poll) error {
+	return lst.mutatePolls(ctx, func() error { return lst.mem.UpdatePollResource(ctx, poll) })
+}
+
+func (lst *FileVoterList) DeletePollResource(ctx context.Context, id uint) error {
+	return lst.mutatePolls(ctx, func() error { return lst.mem.DeletePollResource(ctx, id) })
+}
+
+func (lst *FileVoterList) GetPollResource(ctx context.Context, id uint) (Poll, error) {
+	return lst.mem.GetPollResource(ctx, id)
+}
+
+func (lst *FileVoterList) GetAllPolls(ctx context.Context) ([]Poll, error) {
+	return lst.mem.GetAllPolls(ctx)
+}
+
+// ReserveIdempotencyKey is not saved, only answered keys are, but it
+// still waits for lst.mu so a failed save cannot roll it back
+func (lst *FileVoterList) ReserveIdempotencyKey(ctx context.Context, key string, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+	return lst.mem.ReserveIdempotencyKey(ctx, key, fingerprint, ttl)
+}
+
+func (lst *FileVoterList) SaveIdempotencyKey(ctx context.Context, key string, record IdempotencyRecord, ttl time.Duration) error {
+	return lst.mutateWith(ctx, func() error { return lst.mem.SaveIdempotencyKey(ctx, key, record, ttl) }, lst.saveIdempotency)
+}
+
+func (lst *FileVoterList) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+	return lst.mem.ReleaseIdempotencyKey(ctx, key)
+}
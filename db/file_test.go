@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestFileRollback makes every save fail and checks a write that could not
+// be saved leaves nothing behind in memory, the voters, the poll index and
+// the polls are all as they were before it
+func TestFileRollback(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	lst, err := NewWithFileInstance(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	poll := Poll{PollID: 1, Title: "Rollback", Status: PollOpen, VotePolicy: PolicyMultiple}
+	if err := lst.AddPollResource(ctx, poll); err != nil {
+		t.Fatal(err)
+	}
+	for id := uint(1); id <= 3; id++ {
+		if _, err := lst.AddVoter(ctx, *NewVoter(id, "Roll", "Back")); err != nil {
+			t.Fatal(err)
+		}
+		if err := lst.AddVoterPollData(ctx, id, poll.PollID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//A directory that is not empty cannot be renamed over, so every save
+	//from here on fails
+	for _, name := range []string{FileVotersName, FilePollsName, FileMetaName} {
+		path := filepath.Join(dir, name)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(path, "in-the-way"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := fileSnapshot(t, lst)
+	updated := *NewVoter(2, "Changed", "Name")
+	updated.Revision = 2
+
+	writes := map[string]func() error{
+		"AddVoter":         func() error { _, err := lst.AddVoter(ctx, *NewVoter(4, "New", "Voter")); return err },
+		"CreateVoter":      func() error { _, err := lst.CreateVoter(ctx, *NewVoter(0, "New", "Voter")); return err },
+		"UpdateVoter":      func() error { return lst.UpdateVoter(ctx, updated) },
+		"AddVoterPollData": func() error { return lst.AddVoterPollData(ctx, 1, poll.PollID) },
+		"DeletePoll":       func() error { return lst.DeletePoll(ctx, 3, poll.PollID) },
+		"DeleteVoter":      func() error { return lst.DeleteVoter(ctx, 2, 0) },
+		"DeleteAll":        func() error { return lst.DeleteAll(ctx) },
+		"AddVoters": func() error {
+			_, err := lst.AddVoters(ctx, []Voter{*NewVoter(5, "New", "Voter"), *NewVoter(6, "New", "Voter")}, false)
+			return err
+		},
+		"AddPollResource": func() error {
+			return lst.AddPollResource(ctx, Poll{PollID: 2, Title: "New", Status: PollOpen})
+		},
+		"DeletePollResource": func() error { return lst.DeletePollResource(ctx, poll.PollID) },
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, ErrStorageUnavailable) {
+			t.Errorf("%s: expected a storage error, got %v", name, err)
+		}
+		if after := fileSnapshot(t, lst); !reflect.DeepEqual(after, before) {
+			t.Errorf("%s: the store changed although the write was not saved\nbefore %+v\nafter  %+v", name, before, after)
+		}
+	}
+}
+
+type fileState struct {
+	voters     []Voter
+	polls      []Poll
+	pollVoters int
+}
+
+func fileSnapshot(t *testing.T, lst *FileVoterList) fileState {
+	ctx := context.Background()
+	page, err := lst.ListVoters(ctx, VoterQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	polls, err := lst.GetAllPolls(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := lst.CountPollVoters(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileState{voters: page.Voters, polls: polls, pollVoters: count}
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// IdempotencyStore remembers the responses to requests that were sent
+// with an Idempotency-Key, so a client that retries gets the first
+// answer again instead of making the change twice.  The keys live in the
+// same backend as the voters, every instance of the API sees the same
+// ones.
+type IdempotencyStore interface {
+	//ReserveIdempotencyKey claims key for a request with the given
+	//fingerprint until ttl runs out.  If the key is already taken the
+	//record holding it is returned and nothing changes, nil means the
+	//caller now holds the key.
+	ReserveIdempotencyKey(ctx context.Context, key string, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error)
+	//SaveIdempotencyKey stores the response to the request holding key,
+	//it is handed out again until ttl runs out
+	SaveIdempotencyKey(ctx context.Context, key string, record IdempotencyRecord, ttl time.Duration) error
+	//ReleaseIdempotencyKey gives a reserved key up without a response,
+	//the next request with it runs as if it was the first
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+// IdempotencyRecord is what is kept for one Idempotency-Key.  The
+// fingerprint identifies the request, Status, Header and Body are the
+// response and stay empty while the first request is still running.
+type IdempotencyRecord struct {
+	Fingerprint string            `json:"fingerprint"`
+	Status      int               `json:"status,omitempty"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+	Expires     time.Time         `json:"expires"`
+}
+
+// Done is true once the response has been saved
+func (r *IdempotencyRecord) Done() bool {
+	return r.Status != 0
+}
+
+// idempotencySweepEvery is how often the memory backend drops expired
+// keys, they are ignored as soon as they expire either way
+const idempotencySweepEvery = time.Minute
+
+func (lst *MemoryVoterList) ReserveIdempotencyKey(ctx context.Context, key string, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(lst.idempotencySwept) > idempotencySweepEvery {
+		for k, record := range lst.idempotency {
+			if !now.Before(record.Expires) {
+				delete(lst.idempotency, k)
+			}
+		}
+		lst.idempotencySwept = now
+	}
+
+	if record, ok := lst.idempotency[key]; ok && now.Before(record.Expires) {
+		return &record, nil
+	}
+	lst.idempotency[key] = IdempotencyRecord{Fingerprint: fingerprint, Expires: now.Add(ttl)}
+	return nil, nil
+}
+
+func (lst *MemoryVoterList) SaveIdempotencyKey(ctx context.Context, key string, record IdempotencyRecord, ttl time.Duration) error {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	record.Expires = time.Now().Add(ttl)
+	if old, ok := lst.idempotency[key]; ok {
+		lst.undo.idempotencyKey(key, &old)
+	} else {
+		lst.undo.idempotencyKey(key, nil)
+	}
+	lst.idempotency[key] = record
+	return nil
+}
+
+func (lst *MemoryVoterList) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	delete(lst.idempotency, key)
+	return nil
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"sort"
+	"strconv"
+)
+
+// The poll index maps a poll to the voters that have it in their
+// history, so "who voted in poll X" does not mean loading every voter.
+// Every backend keeps it up to date whenever a history changes, and can
+// rebuild it from scratch should it ever drift.
+
+// pollSet returns the distinct polls in the voters history
+func pollSet(v *Voter) map[uint]bool {
+	polls := make(map[uint]bool)
+	if v == nil {
+		return polls
+	}
+	for _, poll := range v.VoteHistory {
+		polls[poll.PollID] = true
+	}
+	return polls
+}
+
+// indexChanges works out which polls the voter has to be added to and
+// removed from when their document changes from old to updated.  Either
+// one may be nil for a voter that is being created or deleted.
+func indexChanges(old, updated *Voter) (added, removed []uint) {
+	before, after := pollSet(old), pollSet(updated)
+	for id := range after {
+		if !before[id] {
+			added = append(added, id)
+		}
+	}
+	for id := range before {
+		if !after[id] {
+			removed = append(removed, id)
+		}
+	}
+	return added, removed
+}
+
+// pageOfIDs cuts one page out of a list of voter ids.  The cursor is
+// simply the last id of the previous page.
+func pageOfIDs(ids []uint, limit int, cursor string) ([]uint, string, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	start := 0
+	if cursor != "" {
+		after, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, "", ErrInvalidQuery
+		}
+		start = sort.Search(len(ids), func(i int) bool { return uint64(ids[i]) > after })
+	}
+
+	end := start + limit
+	if end > len(ids) {
+		end = len(ids)
+	}
+
+	next := ""
+	if end < len(ids) {
+		next = strconv.FormatUint(uint64(ids[end-1]), 10)
+	}
+	return ids[start:end], next, nil
+}
+
+// voteChanges works out which individual votes were added to and
+// removed from the history when a voter changes from old to updated.  A
+// revote shows up as one removed and one added vote.
+func voteChanges(old, updated *Voter) (added, removed []VoterPoll) {
+	type voteKey struct {
+		poll uint
+		date int64
+	}
+	count := make(map[voteKey]int)
+	if old != nil {
+		for _, v := range old.VoteHistory {
+			count[voteKey{v.PollID, v.VoteDate.UnixNano()}]--
+		}
+	}
+	if updated != nil {
+		for _, v := range updated.VoteHistory {
+			k := voteKey{v.PollID, v.VoteDate.UnixNano()}
+			if count[k] < 0 {
+				count[k]++
+				continue
+			}
+			added = append(added, v)
+		}
+	}
+	if old != nil {
+		for _, v := range old.VoteHistory {
+			k := voteKey{v.PollID, v.VoteDate.UnixNano()}
+			if count[k] < 0 {
+				count[k]++
+				removed = append(removed, v)
+			}
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,704 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryVoterList is an in-process VoterStore.  Nothing survives a
+// restart, but it needs no redis instance so it is a good fit for
+// local development and tests.
+type MemoryVoterList struct {
+	mu     sync.RWMutex
+	voters map[uint]Voter
+	polls  map[uint]Poll
+
+	//poll id -> set of voter ids, see index.go
+	pollIndex map[uint]map[uint]bool
+
+	schemaVersion int
+	//lastVoterID is the highest voter id ever stored, CreateVoter
+	//carries on from there
+	lastVoterID uint
+
+	//Idempotency-Key -> what was answered, see idempotency.go
+	idempotency      map[string]IdempotencyRecord
+	idempotencySwept time.Time
+
+	//undo is set while the writes are tracked, see track
+	undo *undoLog
+
+	votingRules
+}
+
+func NewMemoryVoterList() *MemoryVoterList {
+	return &MemoryVoterList{
+		voters:    make(map[uint]Voter),
+		polls:     make(map[uint]Poll),
+		pollIndex: make(map[uint]map[uint]bool),
+
+		idempotency: make(map[string]IdempotencyRecord),
+		//nothing older was ever stored in it
+		schemaVersion: SchemaVersion(),
+	}
+}
+
+// copyVoter returns a voter that does not share its slices or pointers
+// with the original, so callers can never mutate what is stored in the map
+func copyVoter(v Voter) Voter {
+	if v.VoteHistory != nil {
+		history := make([]VoterPoll, len(v.VoteHistory))
+		copy(history, v.VoteHistory)
+		v.VoteHistory = history
+	}
+	if v.VoteAudit != nil {
+		audit := make([]VoteChange, len(v.VoteAudit))
+		copy(audit, v.VoteAudit)
+		v.VoteAudit = audit
+	}
+	if v.Address != nil {
+		address := *v.Address
+		v.Address = &address
+	}
+	return v
+}
+
+// undoLog is how the records a write touched looked before it, so a
+// write can be taken back without a copy of the whole store, see
+// FileVoterList.mutateWith.  Only the first version of each record is
+// kept, that is the one to go back to, and nil is a record that did not
+// exist.  The stored records are never changed in place, every write
+// puts a new copy in the map, so keeping the old value is enough.
+type undoLog struct {
+	voters        map[uint]*Voter
+	polls         map[uint]*Poll
+	idempotency   map[string]*IdempotencyRecord
+	schemaVersion int
+	lastVoterID   uint
+}
+
+// voter, poll and idempotencyKey record a record about to be written,
+// they do nothing when the writes are not tracked
+func (u *undoLog) voter(id uint, old *Voter) {
+	if u == nil {
+		return
+	}
+	if _, ok := u.voters[id]; !ok {
+		u.voters[id] = old
+	}
+}
+
+func (u *undoLog) poll(id uint, old *Poll) {
+	if u == nil {
+		return
+	}
+	if _, ok := u.polls[id]; !ok {
+		u.polls[id] = old
+	}
+}
+
+func (u *undoLog) idempotencyKey(key string, old *IdempotencyRecord) {
+	if u == nil {
+		return
+	}
+	if _, ok := u.idempotency[key]; !ok {
+		u.idempotency[key] = old
+	}
+}
+
+// track starts recording what the writes change until commit or
+// rollback is called, one write at a time
+func (lst *MemoryVoterList) track() {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	lst.undo = &undoLog{
+		voters:        make(map[uint]*Voter),
+		polls:         make(map[uint]*Poll),
+		idempotency:   make(map[string]*IdempotencyRecord),
+		schemaVersion: lst.schemaVersion,
+		lastVoterID:   lst.lastVoterID,
+	}
+}
+
+// commit keeps the writes made since track
+func (lst *MemoryVoterList) commit() {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	lst.undo = nil
+}
+
+// rollback puts back every record written since track as it was
+func (lst *MemoryVoterList) rollback() {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	u := lst.undo
+	lst.undo = nil
+	if u == nil {
+		return
+	}
+
+	for id, old := range u.voters {
+		var current *Voter
+		if voter, ok := lst.voters[id]; ok {
+			current = &voter
+		}
+		if old == nil {
+			delete(lst.voters, id)
+		} else {
+			lst.voters[id] = *old
+		}
+		lst.reindex(id, current, old)
+	}
+	for id, old := range u.polls {
+		if old == nil {
+			delete(lst.polls, id)
+		} else {
+			lst.polls[id] = *old
+		}
+	}
+	for key, old := range u.idempotency {
+		if old == nil {
+			delete(lst.idempotency, key)
+		} else {
+			lst.idempotency[key] = *old
+		}
+	}
+	lst.schemaVersion = u.schemaVersion
+	lst.lastVoterID = u.lastVoterID
+}
+
+// put stores the voter and brings the poll index in line with their
+// history, old is the previous version or nil.  The write lock must be
+// held.
+func (lst *MemoryVoterList) put(old *Voter, voter Voter) {
+	lst.undo.voter(voter.VoterId, old)
+	lst.voters[voter.VoterId] = voter
+	if voter.VoterId > lst.lastVoterID {
+		lst.lastVoterID = voter.VoterId
+	}
+	lst.reindex(voter.VoterId, old, &voter)
+}
+
+// remove deletes the voter and drops them from the poll index.  The
+// write lock must be held.
+func (lst *MemoryVoterList) remove(old Voter) {
+	lst.undo.voter(old.VoterId, &old)
+	delete(lst.voters, old.VoterId)
+	lst.reindex(old.VoterId, &old, nil)
+}
+
+func (lst *MemoryVoterList) reindex(id uint, old, updated *Voter) {
+	added, removed := indexChanges(old, updated)
+	for _, pollId := range added {
+		if lst.pollIndex[pollId] == nil {
+			lst.pollIndex[pollId] = make(map[uint]bool)
+		}
+		lst.pollIndex[pollId][id] = true
+	}
+	for _, pollId := range removed {
+		delete(lst.pollIndex[pollId], id)
+		if len(lst.pollIndex[pollId]) == 0 {
+			delete(lst.pollIndex, pollId)
+		}
+	}
+}
+
+// Close has nothing to release
+func (lst *MemoryVoterList) Close() error {
+	return nil
+}
+
+// Ping always succeeds, there is nothing that can be unavailable
+func (lst *MemoryVoterList) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (lst *MemoryVoterList) AddVoter(ctx context.Context, voter Voter) (Voter, error) {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	if _, ok := lst.voters[voter.VoterId]; ok {
+		return Voter{}, ErrVoterExists
+	}
+
+	voter.Revision = nextRevision(nil)
+	lst.put(nil, copyVoter(voter))
+	return copyVoter(voter), nil
+}
+
+func (lst *MemoryVoterList) AddVoters(ctx context.Context, voters []Voter, dryRun bool) ([]error, error) {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	results := make([]error, len(voters))
+	seen := make(map[uint]bool, len(voters))
+	for i, voter := range voters {
+		_, exists := lst.voters[voter.VoterId]
+		if exists || seen[voter.VoterId] {
+			results[i] = ErrVoterExists
+		} else if !dryRun {
+			voter.Revision = nextRevision(nil)
+			lst.put(nil, copyVoter(voter))
+		}
+		seen[voter.VoterId] = true
+	}
+	return results, nil
+}
+
+func (lst *MemoryVoterList) CreateVoter(ctx context.Context, voter Voter) (Voter, error) {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	voter.VoterId = lst.lastVoterID + 1
+	voter.Revision = nextRevision(nil)
+	lst.put(nil, copyVoter(voter))
+	return voter, nil
+}
+
+func (lst *MemoryVoterList) UpdateVoter(ctx context.Context, voter Voter) error {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	old, ok := lst.voters[voter.VoterId]
+	if !ok {
+		return ErrVoterNotFound
+	}
+
+	voter.Revision = nextRevision(&old)
+	lst.put(&old, copyVoter(voter))
+	return nil
+}
+
+func (lst *MemoryVoterList) PatchVoter(ctx context.Context, id uint, fn func(voter *Voter) error) (Voter, error) {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	old, ok := lst.voters[id]
+	if !ok {
+		return Voter{}, ErrVoterNotFound
+	}
+
+	voter := copyVoter(old)
+	if err := fn(&voter); err != nil {
+		return Voter{}, err
+	}
+	voter.Revision = nextRevision(&old)
+	lst.put(&old, copyVoter(voter))
+	return voter, nil
+}
+
+func (lst *MemoryVoterList) DeleteVoter(ctx context.Context, id uint, revision uint64) error {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	old, ok := lst.voters[id]
+	if !ok {
+		return ErrVoterNotFound
+	}
+	if err := old.CheckRevision(revision); err != nil {
+		return err
+	}
+
+	lst.remove(old)
+	return nil
+}
+
+func (lst *MemoryVoterList) DeleteAll(ctx context.Context) error {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	for id, voter := range lst.voters {
+		voter := voter
+		lst.undo.voter(id, &voter)
+	}
+	lst.voters = make(map[uint]Voter)
+	lst.pollIndex = make(map[uint]map[uint]bool)
+	return nil
+}
+
+func (lst *MemoryVoterList) GetSingleVoterResource(ctx context.Context, id uint) (Voter, error) {
+	lst.mu.RLock()
+	defer lst.mu.RUnlock()
+
+	voter, ok := lst.voters[id]
+	if !ok {
+		return Voter{}, ErrVoterNotFound
+	}
+
+	return copyVoter(voter), nil
+}
+
+func (lst *MemoryVoterList) GetAllVoters(ctx context.Context) ([]Voter, error) {
+	lst.mu.RLock()
+	defer lst.mu.RUnlock()
+
+	var voterList []Voter
+	for _, voter := range lst.voters {
+		voterList = append(voterList, copyVoter(voter))
+	}
+
+	return voterList, nil
+}
+
+func (lst *MemoryVoterList) ListVoters(ctx context.Context, q VoterQuery) (VoterPage, error) {
+	p, err := newPager(q)
+	if err != nil {
+		return VoterPage{}, err
+	}
+
+	lst.mu.RLock()
+	defer lst.mu.RUnlock()
+	for _, voter := range lst.voters {
+		p.add(voter)
+	}
+
+	//Only the page is copied, not everything the pager looked at
+	page := p.page()
+	for i, voter := range page.Voters {
+		page.Voters[i] = copyVoter(voter)
+	}
+	return page, nil
+}
+
+// ScanVoters goes through the voters in id order.  The lock is only
+// held to look up each voter, fn may be slow (it is writing to a client)
+// and must not hold up the writers.
+func (lst *MemoryVoterList) ScanVoters(ctx context.Context, q VoterQuery, fn func(voter Voter) error) error {
+	lst.mu.RLock()
+	ids := make([]uint, 0, len(lst.voters))
+	for id := range lst.voters {
+		ids = append(ids, id)
+	}
+	lst.mu.RUnlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return storageError(err)
+		}
+
+		lst.mu.RLock()
+		voter, ok := lst.voters[id]
+		if ok {
+			voter = copyVoter(voter)
+		}
+		lst.mu.RUnlock()
+
+		if !ok || !q.Matches(voter) {
+			continue
+		}
+		if err := fn(voter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lst *MemoryVoterList) GetVoterHistory(ctx context.Context, id uint) ([]VoterPoll, error) {
+	voter, err := lst.GetSingleVoterResource(ctx, id)
+	if err != nil {
+		return []VoterPoll{}, err
+	}
+
+	return voter.VoteHistory, nil
+}
+
+func (lst *MemoryVoterList) GetVoterPollData(ctx context.Context, voterId uint, pollId uint) (*VoterPoll, error) {
+	voter, err := lst.GetSingleVoterResource(ctx, voterId)
+	if err != nil {
+		return &VoterPoll{}, err
+	}
+
+	for j := 0; j < len(voter.VoteHistory); j++ {
+		currentPoll := voter.VoteHistory[j]
+		if currentPoll.PollID == pollId {
+			return &currentPoll, nil
+		}
+	}
+
+	return nil, ErrPollNotFound
+}
+
+// AddVoterPollData mirrors the redis backend, the voter and the poll
+// must exist and the poll must be open
+func (lst *MemoryVoterList) AddVoterPollData(ctx context.Context, voterId uint, pollId uint) error {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	poll, ok := lst.polls[pollId]
+	if !ok {
+		return ErrPollNotFound
+	}
+	now := time.Now()
+	if err := poll.AcceptingVotes(now); err != nil {
+		return err
+	}
+
+	old, ok := lst.voters[voterId]
+	if !ok {
+		return ErrVoterNotFound
+	}
+
+	voter := copyVoter(old)
+	if err := voter.recordVote(pollId, poll.votePolicy(&lst.votingRules), now); err != nil {
+		return err
+	}
+	voter.Revision = nextRevision(&old)
+	lst.put(&old, voter)
+
+	return nil
+}
+
+func (lst *MemoryVoterList) DeletePoll(ctx context.Context, voterId uint, pollId uint) error {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	old, ok := lst.voters[voterId]
+	if !ok {
+		return ErrVoterNotFound
+	}
+
+	voter := copyVoter(old)
+	if !voter.removePoll(pollId) {
+		return ErrPollNotFound
+	}
+	voter.Revision = nextRevision(&old)
+	lst.put(&old, voter)
+
+	return nil
+}
+
+func (lst *MemoryVoterList) AddPollResource(ctx context.Context, poll Poll) error {
+	poll = poll.withDefaults()
+	if err := poll.Validate(); err != nil {
+		return err
+	}
+
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	if _, ok := lst.polls[poll.PollID]; ok {
+		return ErrPollExists
+	}
+
+	lst.undo.poll(poll.PollID, nil)
+	lst.polls[poll.PollID] = copyPoll(poll)
+	return nil
+}
+
+func (lst *MemoryVoterList) UpdatePollResource(ctx context.Context, poll Poll) error {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	existing, ok := lst.polls[poll.PollID]
+	if !ok {
+		return ErrPollNotFound
+	}
+	if err := checkPollUpdate(existing, poll); err != nil {
+		return err
+	}
+
+	lst.undo.poll(poll.PollID, &existing)
+	lst.polls[poll.PollID] = copyPoll(poll)
+	return nil
+}
+
+func (lst *MemoryVoterList) DeletePollResource(ctx context.Context, id uint) error {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	existing, ok := lst.polls[id]
+	if !ok {
+		return ErrPollNotFound
+	}
+
+	lst.undo.poll(id, &existing)
+	delete(lst.polls, id)
+	return nil
+}
+
+func (lst *MemoryVoterList) GetPollResource(ctx context.Context, id uint) (Poll, error) {
+	lst.mu.RLock()
+	defer lst.mu.RUnlock()
+
+	poll, ok := lst.polls[id]
+	if !ok {
+		return Poll{}, ErrPollNotFound
+	}
+
+	return copyPoll(poll), nil
+}
+
+func (lst *MemoryVoterList) GetAllPolls(ctx context.Context) ([]Poll, error) {
+	lst.mu.RLock()
+	defer lst.mu.RUnlock()
+
+	var pollList []Poll
+	for _, poll := range lst.polls {
+		pollList = append(pollList, copyPoll(poll))
+	}
+
+	return pollList, nil
+}
+
+func (lst *MemoryVoterList) GetPollVoters(ctx context.Context, pollId uint, limit int, cursor string) (VoterPage, error) {
+	lst.mu.RLock()
+	defer lst.mu.RUnlock()
+
+	ids := make([]uint, 0, len(lst.pollIndex[pollId]))
+	for id := range lst.pollIndex[pollId] {
+		ids = append(ids, id)
+	}
+
+	page, next, err := pageOfIDs(ids, limit, cursor)
+	if err != nil {
+		return VoterPage{}, err
+	}
+
+	voters := make([]Voter, 0, len(page))
+	for _, id := range page {
+		voters = append(voters, copyVoter(lst.voters[id]))
+	}
+
+	return VoterPage{Voters: voters, NextCursor: next, Total: len(ids)}, nil
+}
+
+func (lst *MemoryVoterList) CountPollVoters(ctx context.Context, pollId uint) (int, error) {
+	lst.mu.RLock()
+	defer lst.mu.RUnlock()
+
+	return len(lst.pollIndex[pollId]), nil
+}
+
+// RebuildPollIndex throws the index away and rebuilds it from the voter
+// histories
+func (lst *MemoryVoterList) RebuildPollIndex(ctx context.Context) error {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	lst.pollIndex = make(map[uint]map[uint]bool)
+	for id := range lst.voters {
+		voter := lst.voters[id]
+		lst.reindex(id, nil, &voter)
+	}
+	return nil
+}
+
+func (lst *MemoryVoterList) SchemaVersion(ctx context.Context) (int, error) {
+	lst.mu.RLock()
+	defer lst.mu.RUnlock()
+	return lst.schemaVersion, nil
+}
+
+func (lst *MemoryVoterList) SetSchemaVersion(ctx context.Context, version int) error {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+	lst.schemaVersion = version
+	return nil
+}
+
+func (lst *MemoryVoterList) PollStatistics(ctx context.Context) ([]PollStats, error) {
+	lst.mu.RLock()
+	defer lst.mu.RUnlock()
+
+	votes := make(map[uint]int)
+	for _, voter := range lst.voters {
+		for _, poll := range voter.VoteHistory {
+			votes[poll.PollID]++
+		}
+	}
+
+	stats := make([]PollStats, 0, len(lst.pollIndex))
+	for pollId, voters := range lst.pollIndex {
+		stats = append(stats, PollStats{
+			PollID:       pollId,
+			Participants: len(voters),
+			Votes:        votes[pollId],
+			Turnout:      turnout(len(voters), len(lst.voters)),
+		})
+	}
+	sortPollStats(stats)
+
+	return stats, nil
+}
+
+func (lst *MemoryVoterList) PollReport(ctx context.Context, pollId uint, bucket string) (PollReport, error) {
+	bucket, err := validBucket(bucket)
+	if err != nil {
+		return PollReport{}, err
+	}
+
+	lst.mu.RLock()
+	defer lst.mu.RUnlock()
+
+	report := PollReport{
+		PollStats:  PollStats{PollID: pollId},
+		Registered: len(lst.voters),
+		Bucket:     bucket,
+	}
+	counts := make(map[time.Time]int)
+
+	//Only the voters in the index took part, no need to look at anybody
+	//else
+	for id := range lst.pollIndex[pollId] {
+		history := lst.voters[id].VoteHistory
+		report.Participants++
+		if isFirstVoteIn(history, pollId) {
+			report.FirstTime++
+		} else {
+			report.Returning++
+		}
+		for _, poll := range history {
+			if poll.PollID == pollId {
+				report.Votes++
+				counts[truncateVote(poll.VoteDate, bucket)]++
+			}
+		}
+	}
+	report.Turnout = turnout(report.Participants, report.Registered)
+	report.Histogram = histogram(counts)
+
+	return report, nil
+}
+
+func (lst *MemoryVoterList) TopVoters(ctx context.Context, n int) ([]VoterActivity, error) {
+	lst.mu.RLock()
+	defer lst.mu.RUnlock()
+
+	activity := make([]VoterActivity, 0, len(lst.voters))
+	for _, voter := range lst.voters {
+		if len(voter.VoteHistory) == 0 {
+			continue
+		}
+		activity = append(activity, VoterActivity{
+			VoterID:   voter.VoterId,
+			FirstName: voter.FirstName,
+			LastName:  voter.LastName,
+			Votes:     len(voter.VoteHistory),
+		})
+	}
+	sortActivity(activity)
+
+	if n > 0 && n < len(activity) {
+		activity = activity[:n]
+	}
+	return activity, nil
+}
+
+// VoterCounts returns the number of registered voters and the number of
+// votes across all their histories
+func (lst *MemoryVoterList) VoterCounts(ctx context.Context) (int, int, error) {
+	lst.mu.RLock()
+	defer lst.mu.RUnlock()
+
+	votes := 0
+	for _, voter := range lst.voters {
+		votes += len(voter.VoteHistory)
+	}
+	return len(lst.voters), votes, nil
+}
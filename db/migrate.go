@@ -0,0 +1,171 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Migration is one step in bringing stored data up to date.  Up changes
+// the data and returns how many records it touched, with dryRun it only
+// counts them.  Migrations have to be safe to run twice, a crash
+// between Up and recording the version runs it again.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, store VoterStore, dryRun bool) (int, error)
+}
+
+// Migrations are applied in order, a new one goes at the end with the
+// next version number.  Never change or remove one that has shipped.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "give voters without a vote history an empty one",
+		Up:          emptyHistories,
+	},
+	{
+		Version:     2,
+		Description: "create closed polls for votes in polls that were never defined",
+		Up:          missingPolls,
+	},
+	{
+		Version:     3,
+		Description: "rebuild the poll index and statistics",
+		Up:          rebuildIndex,
+	},
+	{
+		Version:     4,
+		Description: "give voters written before revisions were kept revision 1",
+		Up:          firstRevisions,
+	},
+}
+
+// SchemaVersion is the version of the data this code writes
+func SchemaVersion() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// MigrationResult is what one migration did, or with dryRun would do
+type MigrationResult struct {
+	Migration
+	Changed int
+}
+
+// Migrate applies the migrations store has not seen yet and records the
+// new version after each one, so an interrupted run picks up where it
+// stopped.  With dryRun nothing is written and the results say what
+// would be changed.
+func Migrate(ctx context.Context, store VoterStore, dryRun bool) ([]MigrationResult, error) {
+	current, err := store.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if current > SchemaVersion() {
+		return nil, fmt.Errorf("%w: version %d, this one knows up to %d", ErrSchemaTooNew, current, SchemaVersion())
+	}
+
+	var results []MigrationResult
+	for _, m := range Migrations {
+		if m.Version <= current {
+			continue
+		}
+		n, err := m.Up(ctx, store, dryRun)
+		if err != nil {
+			return results, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		results = append(results, MigrationResult{Migration: m, Changed: n})
+
+		if dryRun {
+			continue
+		}
+		if err := store.SetSchemaVersion(ctx, m.Version); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// emptyHistories replaces a null votehistory, written by older versions
+// for voters created without one, with an empty list
+func emptyHistories(ctx context.Context, store VoterStore, dryRun bool) (int, error) {
+	n := 0
+	err := store.ScanVoters(ctx, VoterQuery{}, func(voter Voter) error {
+		if voter.VoteHistory != nil {
+			return nil
+		}
+		n++
+		if dryRun {
+			return nil
+		}
+		voter.VoteHistory = []VoterPoll{}
+		return store.UpdateVoter(ctx, voter)
+	})
+	return n, err
+}
+
+// missingPolls defines every poll that has votes but no definition,
+// votes used to be accepted for any poll id.  They are created closed so
+// no new votes land in them by accident.
+func missingPolls(ctx context.Context, store VoterStore, dryRun bool) (int, error) {
+	polls, err := store.GetAllPolls(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defined := make(map[uint]bool, len(polls))
+	for _, p := range polls {
+		defined[p.PollID] = true
+	}
+
+	var missing []uint
+	err = store.ScanVoters(ctx, VoterQuery{}, func(voter Voter) error {
+		for _, vote := range voter.VoteHistory {
+			if !defined[vote.PollID] {
+				defined[vote.PollID] = true
+				missing = append(missing, vote.PollID)
+			}
+		}
+		return nil
+	})
+	if err != nil || dryRun {
+		return len(missing), err
+	}
+
+	for _, id := range missing {
+		poll := Poll{
+			PollID: id,
+			Title:  fmt.Sprintf("Poll %d", id),
+			Status: PollClosed,
+		}
+		if err := store.AddPollResource(ctx, poll); err != nil && !errors.Is(err, ErrPollExists) {
+			return 0, err
+		}
+	}
+	return len(missing), nil
+}
+
+// rebuildIndex brings the index and the statistics in line with the
+// voters the earlier migrations may have changed
+func rebuildIndex(ctx context.Context, store VoterStore, dryRun bool) (int, error) {
+	if dryRun {
+		return 0, nil
+	}
+	return 0, store.RebuildPollIndex(ctx)
+}
+
+// firstRevisions writes every voter that has no revision yet, the write
+// itself gives them revision 1
+func firstRevisions(ctx context.Context, store VoterStore, dryRun bool) (int, error) {
+	n := 0
+	err := store.ScanVoters(ctx, VoterQuery{}, func(voter Voter) error {
+		if voter.Revision != 0 {
+			return nil
+		}
+		n++
+		if dryRun {
+			return nil
+		}
+		return store.UpdateVoter(ctx, voter)
+	})
+	return n, err
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PollStatus is where a poll is in its lifecycle
+type PollStatus string
+
+const (
+	PollDraft    PollStatus = "draft"
+	PollOpen     PollStatus = "open"
+	PollClosed   PollStatus = "closed"
+	PollArchived PollStatus = "archived"
+)
+
+// pollTransitions lists the statuses a poll may move to from each status
+var pollTransitions = map[PollStatus][]PollStatus{
+	PollDraft:    {PollOpen, PollArchived},
+	PollOpen:     {PollClosed},
+	PollClosed:   {PollOpen, PollArchived},
+	PollArchived: {},
+}
+
+// Poll describes a poll voters can vote in.  OpensAt and ClosesAt are
+// optional, when set a vote is only accepted inside that window and
+// while the status is open.  VotePolicy overrides the configured
+// VotingRules for this poll when it is set.
+type Poll struct {
+	PollID      uint       `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description,omitempty"`
+	Options     []string   `json:"options,omitempty"`
+	OpensAt     *time.Time `json:"opensat,omitempty"`
+	ClosesAt    *time.Time `json:"closesat,omitempty"`
+	Status      PollStatus `json:"status"`
+	VotePolicy  VotePolicy `json:"votepolicy,omitempty"`
+}
+
+// withDefaults fills in the fields a new poll may leave out
+func (p Poll) withDefaults() Poll {
+	if p.Status == "" {
+		p.Status = PollDraft
+	}
+	return p
+}
+
+// Validate checks the poll definition is complete and consistent
+func (p Poll) Validate() error {
+	if p.PollID == 0 {
+		return fmt.Errorf("%w: id is required", ErrInvalidPoll)
+	}
+	if strings.TrimSpace(p.Title) == "" {
+		return fmt.Errorf("%w: title is required", ErrInvalidPoll)
+	}
+	if _, ok := pollTransitions[p.Status]; !ok {
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidPoll, p.Status)
+	}
+	if p.OpensAt != nil && p.ClosesAt != nil && !p.ClosesAt.After(*p.OpensAt) {
+		return fmt.Errorf("%w: closesat must be after opensat", ErrInvalidPoll)
+	}
+	if p.VotePolicy != "" {
+		if _, err := ParseVotePolicy(string(p.VotePolicy)); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPoll, err)
+		}
+	}
+	return nil
+}
+
+// canMoveTo reports whether a poll may change from its current status to
+// next.  Staying in the same status is always allowed.
+func (p Poll) canMoveTo(next PollStatus) bool {
+	if p.Status == next {
+		return true
+	}
+	for _, s := range pollTransitions[p.Status] {
+		if s == next {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPollUpdate validates an update of existing to updated
+func checkPollUpdate(existing, updated Poll) error {
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+	if !existing.canMoveTo(updated.Status) {
+		return fmt.Errorf("%w: cannot move from %s to %s", ErrInvalidPoll, existing.Status, updated.Status)
+	}
+	return nil
+}
+
+// AcceptingVotes returns nil if a vote can be recorded in the poll at
+// the given time, or ErrPollNotOpen explaining why not
+func (p Poll) AcceptingVotes(now time.Time) error {
+	if p.Status != PollOpen {
+		return fmt.Errorf("%w: poll is %s", ErrPollNotOpen, p.Status)
+	}
+	if p.OpensAt != nil && now.Before(*p.OpensAt) {
+		return fmt.Errorf("%w: poll opens at %s", ErrPollNotOpen, p.OpensAt.Format(time.RFC3339))
+	}
+	if p.ClosesAt != nil && !now.Before(*p.ClosesAt) {
+		return fmt.Errorf("%w: poll closed at %s", ErrPollNotOpen, p.ClosesAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// votePolicy picks the poll's own policy, falling back to the rules
+func (p Poll) votePolicy(r *votingRules) VotePolicy {
+	if p.VotePolicy != "" {
+		return p.VotePolicy
+	}
+	return r.policyFor(p.PollID)
+}
+
+// copyPoll returns a poll that does not share its options with p
+func copyPoll(p Poll) Poll {
+	if p.Options != nil {
+		options := make([]string, len(p.Options))
+		copy(options, p.Options)
+		p.Options = options
+	}
+	return p
+}
@@ -0,0 +1,34 @@
+package db
+
+import "time"
+
+// DateLayout is how a Date is written, a plain calendar date
+const DateLayout = "2006-01-02"
+
+// Date is a day without a time of day or a zone, such as a date of
+// birth, written as DateLayout.  It is kept as the text it was sent as
+// and checked by the date rule, so a malformed date is reported with the
+// field it was sent in like any other broken rule.
+type Date string
+
+// NewDate is the date of t in its own zone
+func NewDate(t time.Time) Date {
+	return Date(t.Format(DateLayout))
+}
+
+// Time is the date at midnight UTC, ok is false if it is not a valid
+// date
+func (d Date) Time() (t time.Time, ok bool) {
+	t, err := time.Parse(DateLayout, string(d))
+	return t, err == nil
+}
+
+// Address is where a voter lives, Line2 is the only part that can be
+// left out
+type Address struct {
+	Line1      string `json:"line1" validate:"required,max=128"`
+	Line2      string `json:"line2,omitempty" validate:"max=128"`
+	City       string `json:"city" validate:"required,max=64"`
+	State      string `json:"state" validate:"required,max=64"`
+	PostalCode string `json:"postalcode" validate:"required,max=16"`
+}
@@ -0,0 +1,311 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SortByID        = "id"
+	SortByLastName  = "lastname"
+	SortByFirstName = "firstname"
+	SortByLastVote  = "lastvote"
+
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// VoterQuery describes one page of a voter listing.  The zero value
+// returns the first DefaultPageSize voters ordered by id.
+type VoterQuery struct {
+	Limit  int
+	Cursor string
+
+	//Sort is one of the SortBy constants, prefix it with "-" to sort
+	//in descending order
+	Sort string
+
+	//Filters, the zero value of each one means "do not filter"
+	LastNamePrefix string
+	District       string
+	Precinct       string
+	VotedInPoll    uint
+	VotedAfter     time.Time
+	VotedBefore    time.Time
+}
+
+// VoterPage is the result of a VoterQuery.  NextCursor is empty when
+// there are no more results, Total is the number of voters matching the
+// filters across all pages.
+type VoterPage struct {
+	Voters     []Voter `json:"voters"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	Total      int     `json:"total"`
+}
+
+// sortKey is what a listing is ordered by, and also what the opaque
+// cursor encodes.  Ties are always broken by the voter id so the order
+// is stable between pages.
+type sortKey struct {
+	S  string    `json:"s,omitempty"`
+	T  time.Time `json:"t,omitempty"`
+	ID uint      `json:"id"`
+}
+
+func (a sortKey) compare(b sortKey) int {
+	if c := strings.Compare(a.S, b.S); c != 0 {
+		return c
+	}
+	if !a.T.Equal(b.T) {
+		if a.T.Before(b.T) {
+			return -1
+		}
+		return 1
+	}
+	switch {
+	case a.ID < b.ID:
+		return -1
+	case a.ID > b.ID:
+		return 1
+	}
+	return 0
+}
+
+// LastVoteDate returns the date of the most recent vote in the voters
+// history, or the zero time if they never voted
+func (v Voter) LastVoteDate() time.Time {
+	var last time.Time
+	for _, poll := range v.VoteHistory {
+		if poll.VoteDate.After(last) {
+			last = poll.VoteDate
+		}
+	}
+	return last
+}
+
+func keyFor(v Voter, field string) sortKey {
+	switch field {
+	case SortByLastName:
+		return sortKey{S: strings.ToLower(v.LastName), ID: v.VoterId}
+	case SortByFirstName:
+		return sortKey{S: strings.ToLower(v.FirstName), ID: v.VoterId}
+	case SortByLastVote:
+		return sortKey{T: v.LastVoteDate(), ID: v.VoterId}
+	default:
+		return sortKey{ID: v.VoterId}
+	}
+}
+
+func encodeCursor(k sortKey) string {
+	b, _ := json.Marshal(k)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (sortKey, error) {
+	var k sortKey
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return k, ErrInvalidQuery
+	}
+	if err := json.Unmarshal(b, &k); err != nil {
+		return k, ErrInvalidQuery
+	}
+	return k, nil
+}
+
+// sortField splits a Sort value into the field and direction
+func (q VoterQuery) sortField() (string, bool, error) {
+	field, desc := q.Sort, false
+	if strings.HasPrefix(field, "-") {
+		field, desc = field[1:], true
+	}
+	switch field {
+	case "":
+		return SortByID, desc, nil
+	case SortByID, SortByLastName, SortByFirstName, SortByLastVote:
+		return field, desc, nil
+	default:
+		return "", false, ErrInvalidQuery
+	}
+}
+
+// Validate checks the query can be run without touching any storage
+func (q VoterQuery) Validate() error {
+	if _, _, err := q.sortField(); err != nil {
+		return err
+	}
+	if q.Limit < 0 {
+		return ErrInvalidQuery
+	}
+	if q.Cursor != "" {
+		if _, err := decodeCursor(q.Cursor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches reports whether a voter passes all the filters in the query
+func (q VoterQuery) Matches(v Voter) bool {
+	if q.LastNamePrefix != "" &&
+		!strings.HasPrefix(strings.ToLower(v.LastName), strings.ToLower(q.LastNamePrefix)) {
+		return false
+	}
+	if q.District != "" && !strings.EqualFold(v.District, q.District) {
+		return false
+	}
+	if q.Precinct != "" && !strings.EqualFold(v.Precinct, q.Precinct) {
+		return false
+	}
+
+	if q.VotedInPoll == 0 && q.VotedAfter.IsZero() && q.VotedBefore.IsZero() {
+		return true
+	}
+
+	//The poll and date filters have to be satisfied by the same vote,
+	//"voted in poll X between these dates"
+	for _, poll := range v.VoteHistory {
+		if q.VotedInPoll != 0 && poll.PollID != q.VotedInPoll {
+			continue
+		}
+		if !q.VotedAfter.IsZero() && poll.VoteDate.Before(q.VotedAfter) {
+			continue
+		}
+		if !q.VotedBefore.IsZero() && poll.VoteDate.After(q.VotedBefore) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// pager cuts one page out of a listing.  The voters are handed to add
+// one at a time and in any order, the pager counts those that match the
+// query but only keeps the limit+1 that come first after the cursor, in
+// a heap with the last of them on top.  A page costs a pass over the
+// voters but never more memory than the page itself.  All the backends
+// share this so paging behaves the same wherever the voters are stored.
+type pager struct {
+	q     VoterQuery
+	field string
+	desc  bool
+	limit int
+	after *sortKey
+	total int
+
+	keep []pageEntry
+	ids  map[uint]bool
+}
+
+type pageEntry struct {
+	key   sortKey
+	voter Voter
+}
+
+func newPager(q VoterQuery) (*pager, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	field, desc, _ := q.sortField()
+
+	p := &pager{q: q, field: field, desc: desc, limit: q.Limit}
+	if p.limit == 0 {
+		p.limit = DefaultPageSize
+	}
+	if p.limit > MaxPageSize {
+		p.limit = MaxPageSize
+	}
+	if q.Cursor != "" {
+		after, _ := decodeCursor(q.Cursor)
+		p.after = &after
+	}
+	p.ids = make(map[uint]bool, p.limit+1)
+	return p, nil
+}
+
+// before reports whether a comes before b in the listing
+func (p *pager) before(a, b sortKey) bool {
+	if p.desc {
+		return a.compare(b) > 0
+	}
+	return a.compare(b) < 0
+}
+
+// add counts the voter if it matches the query, and keeps it if it is
+// one of the limit+1 first past the cursor so far.  A voter handed in
+// again while it is kept, as redis SCAN can, is ignored, one that was
+// not kept is counted twice.
+func (p *pager) add(voter Voter) {
+	if !p.q.Matches(voter) || p.ids[voter.VoterId] {
+		return
+	}
+	p.total++
+
+	key := keyFor(voter, p.field)
+	if p.after != nil && !p.before(*p.after, key) {
+		return
+	}
+
+	if len(p.keep) <= p.limit {
+		p.keep = append(p.keep, pageEntry{key: key, voter: voter})
+		p.ids[voter.VoterId] = true
+		p.up(len(p.keep) - 1)
+		return
+	}
+	if !p.before(key, p.keep[0].key) {
+		return
+	}
+	delete(p.ids, p.keep[0].voter.VoterId)
+	p.keep[0] = pageEntry{key: key, voter: voter}
+	p.ids[voter.VoterId] = true
+	p.down(0)
+}
+
+// up and down keep the heap in order, the entry that comes last in the
+// listing is always at the top
+func (p *pager) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !p.before(p.keep[parent].key, p.keep[i].key) {
+			return
+		}
+		p.keep[parent], p.keep[i] = p.keep[i], p.keep[parent]
+		i = parent
+	}
+}
+
+func (p *pager) down(i int) {
+	for {
+		last := i
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(p.keep) && p.before(p.keep[last].key, p.keep[child].key) {
+				last = child
+			}
+		}
+		if last == i {
+			return
+		}
+		p.keep[last], p.keep[i] = p.keep[i], p.keep[last]
+		i = last
+	}
+}
+
+// page returns the page once every voter has been added.  Only the
+// limit+1st voter is there to tell whether there is a next page, it is
+// not returned.
+func (p *pager) page() VoterPage {
+	sort.Slice(p.keep, func(i, j int) bool { return p.before(p.keep[i].key, p.keep[j].key) })
+
+	page := VoterPage{Voters: make([]Voter, 0, len(p.keep)), Total: p.total}
+	for i, entry := range p.keep {
+		if i == p.limit {
+			page.NextCursor = encodeCursor(p.keep[i-1].key)
+			break
+		}
+		page.Voters = append(page.Voters, entry.voter)
+	}
+	return page
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// TestListVotersPaging walks every sort order a page at a time and checks
+// the pages put together are the whole listing, in order, once each
+func TestListVotersPaging(t *testing.T) {
+	lastNames := []string{"Smith", "jones", "Brown", "smith", "Adams", "Jones", "Zhu"}
+	firstNames := []string{"Ann", "bob", "Cid", "ann", "Dee"}
+
+	forEachStore(t, func(t *testing.T, store VoterStore) {
+		ctx := context.Background()
+
+		poll := Poll{PollID: 1, Title: "Paging", Status: PollOpen, VotePolicy: PolicyMultiple}
+		if err := store.AddPollResource(ctx, poll); err != nil {
+			t.Fatal(err)
+		}
+		for id := uint(1); id <= 37; id++ {
+			voter := NewVoter(id, firstNames[id%5], lastNames[id%7])
+			voter.District = fmt.Sprintf("D%d", id%3)
+			if _, err := store.AddVoter(ctx, *voter); err != nil {
+				t.Fatal(err)
+			}
+			//Only some voters vote, the rest tie on the zero time
+			if id%4 == 0 {
+				if err := store.AddVoterPollData(ctx, id, poll.PollID); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		all, err := store.GetAllVoters(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, sortBy := range []string{"", SortByID, SortByLastName, SortByFirstName, SortByLastVote} {
+			for _, desc := range []bool{false, true} {
+				for _, district := range []string{"", "d1"} {
+					q := VoterQuery{Limit: 5, Sort: sortBy, District: district}
+					if desc {
+						q.Sort = "-" + q.Sort
+					}
+					t.Run(fmt.Sprintf("sort=%s,district=%s", q.Sort, district), func(t *testing.T) {
+						want := expectedListing(all, q)
+						var got []uint
+						for pages := 0; ; pages++ {
+							if pages > len(all) {
+								t.Fatal("the cursor never runs out")
+							}
+							page, err := store.ListVoters(ctx, q)
+							if err != nil {
+								t.Fatal(err)
+							}
+							if page.Total != len(want) {
+								t.Errorf("expected a total of %d, found %d", len(want), page.Total)
+							}
+							if len(page.Voters) > q.Limit {
+								t.Errorf("expected at most %d voters on a page, found %d", q.Limit, len(page.Voters))
+							}
+							for _, voter := range page.Voters {
+								got = append(got, voter.VoterId)
+							}
+							if page.NextCursor == "" {
+								break
+							}
+							q.Cursor = page.NextCursor
+						}
+						if !reflect.DeepEqual(got, want) {
+							t.Errorf("listed %v, expected %v", got, want)
+						}
+					})
+				}
+			}
+		}
+	})
+}
+
+// expectedListing is the ids of the whole listing for q, sorted the slow
+// way
+func expectedListing(all []Voter, q VoterQuery) []uint {
+	field, desc, _ := q.sortField()
+
+	var matches []Voter
+	for _, voter := range all {
+		if q.Matches(voter) {
+			matches = append(matches, voter)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		c := keyFor(matches[i], field).compare(keyFor(matches[j], field))
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	ids := make([]uint, len(matches))
+	for i, voter := range matches {
+		ids[i] = voter.VoterId
+	}
+	return ids
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// RedisIdempotencyPrefix is where the idempotency keys are kept, each as
+// a plain string holding the JSON of its IdempotencyRecord.  Redis
+// expires them on its own.
+const RedisIdempotencyPrefix = "idempotency:"
+
+// ReserveIdempotencyKey takes the key with SET NX, if somebody holds it
+// already their record is read back.  A key that expires between the
+// two is simply tried again.
+func (lst *VoterList) ReserveIdempotencyKey(ctx context.Context, key string, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Write)
+	defer cancel()
+
+	redisKey := lst.key(RedisIdempotencyPrefix + key)
+	doc, err := json.Marshal(IdempotencyRecord{Fingerprint: fingerprint, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < RedisMaxRetries; i++ {
+		ok, err := lst.cacheClient.SetNX(ctx, redisKey, doc, ttl).Result()
+		if err != nil {
+			return nil, storageError(err)
+		}
+		if ok {
+			return nil, nil
+		}
+
+		raw, err := lst.cacheClient.Get(ctx, redisKey).Bytes()
+		if isRedisNilError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, storageError(err)
+		}
+		var record IdempotencyRecord
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return nil, storageError(err)
+		}
+		return &record, nil
+	}
+
+	return nil, storageError(fmt.Errorf("%s expired %d times while reserving it", redisKey, RedisMaxRetries))
+}
+
+func (lst *VoterList) SaveIdempotencyKey(ctx context.Context, key string, record IdempotencyRecord, ttl time.Duration) error {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Write)
+	defer cancel()
+
+	record.Expires = time.Now().Add(ttl)
+	doc, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return storageError(lst.cacheClient.Set(ctx, lst.key(RedisIdempotencyPrefix+key), doc, ttl).Err())
+}
+
+func (lst *VoterList) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Write)
+	defer cancel()
+
+	return storageError(lst.cacheClient.Del(ctx, lst.key(RedisIdempotencyPrefix+key)).Err())
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"drexel.edu/todo/logging"
+	"github.com/go-redis/redis/v8"
+)
+
+type redisStartKey struct{}
+
+// redisLogger is a go-redis hook that logs every command with the logger
+// carried by its context, which for API calls is tagged with the
+// request id.  Commands are logged at debug level, failures (other than
+// a missing key) at warn.
+type redisLogger struct{}
+
+func (redisLogger) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisLogger) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	logRedis(ctx, "redis command", []redis.Cmder{cmd})
+	return nil
+}
+
+func (redisLogger) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisLogger) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	logRedis(ctx, "redis pipeline", cmds)
+	return nil
+}
+
+// logRedis writes one line for a command or a whole pipeline, listing
+// the command names and the first error
+func logRedis(ctx context.Context, msg string, cmds []redis.Cmder) {
+	logger := logging.FromContext(ctx)
+
+	var failed error
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && !isRedisNilError(err) {
+			failed = err
+			break
+		}
+	}
+	level := slog.LevelDebug
+	if failed != nil {
+		level = slog.LevelWarn
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	names := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		names[i] = strings.ToUpper(cmd.Name())
+	}
+	attrs := []slog.Attr{slog.String("cmd", strings.Join(names, " "))}
+	if len(cmds) == 1 && len(cmds[0].Args()) > 1 {
+		//the key, never the value, documents may hold personal data
+		attrs = append(attrs, slog.Any("key", cmds[0].Args()[1]))
+	}
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		attrs = append(attrs, slog.Duration("duration", time.Since(start)))
+	}
+	if failed != nil {
+		attrs = append(attrs, slog.String("error", failed.Error()))
+	}
+	logger.LogAttrs(ctx, level, msg, attrs...)
+}
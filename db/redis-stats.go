@@ -0,0 +1,289 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// The redis backend keeps the statistics up to date as votes are written
+// so reading them never means loading every voter:
+//
+//	stats:activity        sorted set, voter id scored by number of votes
+//	stats:pollvotes       hash, poll id -> number of votes
+//	stats:hist:<pollid>   hash, hour ("2006-01-02T15") -> number of votes
+//
+// Participants per poll come from the poll index.  Everything under the
+// stats: prefix is rebuilt by RebuildPollIndex.
+const (
+	RedisStatsPrefix    = "stats:"
+	RedisActivityKey    = RedisStatsPrefix + "activity"
+	RedisPollVotesKey   = RedisStatsPrefix + "pollvotes"
+	RedisHistogramKey   = RedisStatsPrefix + "hist:"
+	redisHistHourFormat = "2006-01-02T15"
+)
+
+func redisHistogramKey(pollId uint) string {
+	return fmt.Sprintf("%s%d", RedisHistogramKey, pollId)
+}
+
+// queueVoterChanges queues everything derived from a voter document, the
+// poll index and the statistics, in the same MULTI as the write itself
+func (lst *VoterList) queueVoterChanges(ctx context.Context, pipe redis.Pipeliner, old, updated *Voter) {
+	lst.queueIndexChanges(ctx, pipe, old, updated)
+	lst.queueStatsChanges(ctx, pipe, old, updated)
+	lst.queueIDChanges(ctx, pipe, old, updated)
+}
+
+func (lst *VoterList) queueStatsChanges(ctx context.Context, pipe redis.Pipeliner, old, updated *Voter) {
+	if updated == nil {
+		pipe.ZRem(ctx, lst.key(RedisActivityKey), strconv.FormatUint(uint64(old.VoterId), 10))
+	} else {
+		pipe.ZAdd(ctx, lst.key(RedisActivityKey), &redis.Z{
+			Score:  float64(len(updated.VoteHistory)),
+			Member: strconv.FormatUint(uint64(updated.VoterId), 10),
+		})
+	}
+
+	added, removed := voteChanges(old, updated)
+	for _, v := range added {
+		field := strconv.FormatUint(uint64(v.PollID), 10)
+		pipe.HIncrBy(ctx, lst.key(RedisPollVotesKey), field, 1)
+		pipe.HIncrBy(ctx, lst.key(redisHistogramKey(v.PollID)), v.VoteDate.UTC().Format(redisHistHourFormat), 1)
+	}
+	for _, v := range removed {
+		field := strconv.FormatUint(uint64(v.PollID), 10)
+		pipe.HIncrBy(ctx, lst.key(RedisPollVotesKey), field, -1)
+		pipe.HIncrBy(ctx, lst.key(redisHistogramKey(v.PollID)), v.VoteDate.UTC().Format(redisHistHourFormat), -1)
+	}
+}
+
+func (lst *VoterList) registeredVoters(ctx context.Context) (int, error) {
+	n, err := lst.cacheClient.ZCard(ctx, lst.key(RedisActivityKey)).Result()
+	if err != nil {
+		return 0, storageError(err)
+	}
+	return int(n), nil
+}
+
+func (lst *VoterList) PollStatistics(ctx context.Context) ([]PollStats, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Scan)
+	defer cancel()
+
+	registered, err := lst.registeredVoters(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	votes, err := lst.cacheClient.HGetAll(ctx, lst.key(RedisPollVotesKey)).Result()
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	var stats []PollStats
+	err = lst.scanKeys(ctx, lst.key(RedisPollIndexPrefix), func(keys []string) error {
+		pipe := lst.cacheClient.Pipeline()
+		cards := make([]*redis.IntCmd, len(keys))
+		for i, key := range keys {
+			cards[i] = pipe.ZCard(ctx, key)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return storageError(err)
+		}
+
+		for i, key := range keys {
+			id, err := strconv.ParseUint(key[len(lst.key(RedisPollIndexPrefix)):], 10, 32)
+			if err != nil {
+				continue
+			}
+			participants := int(cards[i].Val())
+			if participants == 0 {
+				continue
+			}
+			n, _ := strconv.Atoi(votes[strconv.FormatUint(id, 10)])
+			stats = append(stats, PollStats{
+				PollID:       uint(id),
+				Participants: participants,
+				Votes:        n,
+				Turnout:      turnout(participants, registered),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortPollStats(stats)
+
+	return stats, nil
+}
+
+func (lst *VoterList) PollReport(ctx context.Context, pollId uint, bucket string) (PollReport, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Scan)
+	defer cancel()
+
+	bucket, err := validBucket(bucket)
+	if err != nil {
+		return PollReport{}, err
+	}
+
+	report := PollReport{PollStats: PollStats{PollID: pollId}, Bucket: bucket}
+	if report.Registered, err = lst.registeredVoters(ctx); err != nil {
+		return PollReport{}, err
+	}
+
+	field := strconv.FormatUint(uint64(pollId), 10)
+	votes, err := lst.cacheClient.HGet(ctx, lst.key(RedisPollVotesKey), field).Int()
+	if err != nil && !isRedisNilError(err) {
+		return PollReport{}, storageError(err)
+	}
+	report.Votes = votes
+
+	hours, err := lst.cacheClient.HGetAll(ctx, lst.key(redisHistogramKey(pollId))).Result()
+	if err != nil {
+		return PollReport{}, storageError(err)
+	}
+	counts := make(map[time.Time]int)
+	for hour, n := range hours {
+		t, err := time.Parse(redisHistHourFormat, hour)
+		if err != nil {
+			continue
+		}
+		if c, _ := strconv.Atoi(n); c > 0 {
+			counts[truncateVote(t, bucket)] += c
+		}
+	}
+	report.Histogram = histogram(counts)
+
+	//First time versus returning needs the histories, but only of the
+	//voters in the poll index, and only the votehistory part of them
+	members, err := lst.cacheClient.ZRange(ctx, lst.key(redisPollIndexKey(pollId)), 0, -1).Result()
+	if err != nil {
+		return PollReport{}, storageError(err)
+	}
+	for start := 0; start < len(members); start += RedisScanCount {
+		end := start + RedisScanCount
+		if end > len(members) {
+			end = len(members)
+		}
+		keys := make([]string, 0, end-start)
+		for _, m := range members[start:end] {
+			keys = append(keys, lst.key(RedisKeyPrefix+m))
+		}
+
+		res, err := lst.json(ctx).JSONMGet(".votehistory", keys...)
+		if err != nil {
+			return PollReport{}, storageError(err)
+		}
+		for _, raw := range res.([]interface{}) {
+			if raw == nil {
+				continue
+			}
+			var history []VoterPoll
+			if err := json.Unmarshal(raw.([]byte), &history); err != nil {
+				return PollReport{}, err
+			}
+			report.Participants++
+			if isFirstVoteIn(history, pollId) {
+				report.FirstTime++
+			} else {
+				report.Returning++
+			}
+		}
+	}
+	report.Turnout = turnout(report.Participants, report.Registered)
+
+	return report, nil
+}
+
+func (lst *VoterList) TopVoters(ctx context.Context, n int) ([]VoterActivity, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Read)
+	defer cancel()
+
+	//Every voter is in the activity set, those who never voted with a
+	//score of 0 that is left out here.  Redis orders voters with the
+	//same score by name rather than by id like sortActivity, so cutting
+	//at n in redis could keep a different voter from a tie than the
+	//other backends.  Everybody with at least the n-th score is loaded
+	//instead, ties included, and the cut is made after sorting.
+	min := "(0"
+	if n > 0 {
+		nth, err := lst.cacheClient.ZRevRangeByScoreWithScores(ctx, lst.key(RedisActivityKey),
+			&redis.ZRangeBy{Min: min, Max: "+inf", Offset: int64(n - 1), Count: 1}).Result()
+		if err != nil {
+			return nil, storageError(err)
+		}
+		if len(nth) > 0 {
+			min = strconv.FormatFloat(nth[0].Score, 'f', -1, 64)
+		}
+	}
+
+	top, err := lst.cacheClient.ZRevRangeByScoreWithScores(ctx, lst.key(RedisActivityKey),
+		&redis.ZRangeBy{Min: min, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, storageError(err)
+	}
+	if len(top) == 0 {
+		return []VoterActivity{}, nil
+	}
+
+	keys := make([]string, len(top))
+	for i, z := range top {
+		keys[i] = lst.key(RedisKeyPrefix + z.Member.(string))
+	}
+	res, err := lst.json(ctx).JSONMGet(".", keys...)
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	activity := make([]VoterActivity, 0, len(top))
+	for i, raw := range res.([]interface{}) {
+		if raw == nil {
+			continue
+		}
+		var voter Voter
+		if err := json.Unmarshal(raw.([]byte), &voter); err != nil {
+			return nil, err
+		}
+		activity = append(activity, VoterActivity{
+			VoterID:   voter.VoterId,
+			FirstName: voter.FirstName,
+			LastName:  voter.LastName,
+			Votes:     int(top[i].Score),
+		})
+	}
+	sortActivity(activity)
+
+	if n > 0 && n < len(activity) {
+		activity = activity[:n]
+	}
+	return activity, nil
+}
+
+// VoterCounts returns the number of registered voters and the number of
+// votes across all their histories, both from the statistics keys
+func (lst *VoterList) VoterCounts(ctx context.Context) (int, int, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Read)
+	defer cancel()
+
+	voters, err := lst.registeredVoters(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	perPoll, err := lst.cacheClient.HVals(ctx, lst.key(RedisPollVotesKey)).Result()
+	if err != nil {
+		return 0, 0, storageError(err)
+	}
+	votes := 0
+	for _, n := range perPoll {
+		c, _ := strconv.Atoi(n)
+		votes += c
+	}
+
+	return voters, votes, nil
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VotePolicy controls what happens when a voter votes in a poll they
+// already have in their history
+type VotePolicy string
+
+const (
+	//PolicySingle rejects a second vote with ErrAlreadyVoted
+	PolicySingle VotePolicy = "single"
+	//PolicyRevote replaces the earlier vote and keeps an audit entry
+	PolicyRevote VotePolicy = "revote"
+	//PolicyMultiple records every vote
+	PolicyMultiple VotePolicy = "multiple"
+
+	DefaultVotePolicy = PolicySingle
+)
+
+// ParseVotePolicy converts a string such as "revote" into a VotePolicy
+func ParseVotePolicy(s string) (VotePolicy, error) {
+	switch p := VotePolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case PolicySingle, PolicyRevote, PolicyMultiple:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown vote policy %q", s)
+	}
+}
+
+// VoteChange is an audit record of a vote that was replaced because the
+// poll allows revoting
+type VoteChange struct {
+	PollID       uint      `json:"pollid"`
+	PreviousDate time.Time `json:"previousdate"`
+	ChangedAt    time.Time `json:"changedat"`
+}
+
+// VotingRules holds the vote policy for each poll.  Polls that are not
+// listed use Default.
+type VotingRules struct {
+	Default VotePolicy
+	Polls   map[uint]VotePolicy
+}
+
+// ParseVotingRules builds the rules from a default policy and a list of
+// per poll overrides in the form "59231=revote,12345=multiple"
+func ParseVotingRules(def string, overrides string) (VotingRules, error) {
+	rules := VotingRules{Default: DefaultVotePolicy, Polls: map[uint]VotePolicy{}}
+
+	if def != "" {
+		p, err := ParseVotePolicy(def)
+		if err != nil {
+			return rules, err
+		}
+		rules.Default = p
+	}
+
+	for _, entry := range strings.Split(overrides, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		id, policy, ok := strings.Cut(entry, "=")
+		if !ok {
+			return rules, fmt.Errorf("poll policy %q must look like <pollid>=<policy>", entry)
+		}
+		pollId, err := strconv.ParseUint(strings.TrimSpace(id), 10, 32)
+		if err != nil {
+			return rules, fmt.Errorf("poll policy %q: bad poll id", entry)
+		}
+		p, err := ParseVotePolicy(policy)
+		if err != nil {
+			return rules, err
+		}
+		rules.Polls[uint(pollId)] = p
+	}
+
+	return rules, nil
+}
+
+// PolicyFor returns the policy that applies to the poll
+func (r VotingRules) PolicyFor(pollId uint) VotePolicy {
+	if p, ok := r.Polls[pollId]; ok {
+		return p
+	}
+	if r.Default == "" {
+		return DefaultVotePolicy
+	}
+	return r.Default
+}
+
+// votingRules is embedded in every store so they all share the same way
+// of configuring and looking up the rules
+type votingRules struct {
+	rulesMu sync.RWMutex
+	rules   VotingRules
+}
+
+// SetVotingRules replaces the vote policies the store enforces
+func (r *votingRules) SetVotingRules(rules VotingRules) {
+	r.rulesMu.Lock()
+	defer r.rulesMu.Unlock()
+	r.rules = rules
+}
+
+func (r *votingRules) policyFor(pollId uint) VotePolicy {
+	r.rulesMu.RLock()
+	defer r.rulesMu.RUnlock()
+	return r.rules.PolicyFor(pollId)
+}
+
+// recordVote adds a vote in pollId to the voters history, following the
+// policy for repeat votes
+func (v *Voter) recordVote(pollId uint, policy VotePolicy, now time.Time) error {
+	for j := range v.VoteHistory {
+		if v.VoteHistory[j].PollID != pollId {
+			continue
+		}
+
+		switch policy {
+		case PolicyMultiple:
+			//fall out of the loop and append below
+		case PolicyRevote:
+			v.VoteAudit = append(v.VoteAudit, VoteChange{
+				PollID:       pollId,
+				PreviousDate: v.VoteHistory[j].VoteDate,
+				ChangedAt:    now,
+			})
+			v.VoteHistory[j].VoteDate = now
+			return nil
+		default:
+			return ErrAlreadyVoted
+		}
+		break
+	}
+
+	v.VoteHistory = append(v.VoteHistory, VoterPoll{PollID: pollId, VoteDate: now})
+	return nil
+}
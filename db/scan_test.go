@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"runtime"
+	"testing"
+)
+
+const (
+	scanSmallRoll = 2000
+	scanLargeRoll = 20000
+
+	//scanBytesPerVoter is how much the memory held during a scan may
+	//grow with each voter on the roll.  The memory store keeps the ids,
+	//8 bytes a voter, so it can go in id order, holding on to a key or a
+	//voter for the length of the scan costs several times that.
+	scanBytesPerVoter = 16
+)
+
+// TestScanVotersMemory runs ScanVoters, what an export goes through, over
+// a small and a large voter roll and checks the memory it holds on to
+// does not grow with the roll
+func TestScanVotersMemory(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store VoterStore) {
+		small := scanGrowth(t, store, 0, scanSmallRoll)
+		large := scanGrowth(t, store, scanSmallRoll, scanLargeRoll)
+
+		perVoter := float64(large-small) / float64(scanLargeRoll-scanSmallRoll)
+		if perVoter > scanBytesPerVoter {
+			t.Errorf("the scan held %d bytes over %d voters and %d over %d, %.1f bytes a voter",
+				small, scanSmallRoll, large, scanLargeRoll, perVoter)
+		}
+	})
+}
+
+// scanGrowth adds voters to the store until there are size of them, then
+// returns the most the live heap grew by while ScanVoters went through
+// them all
+func scanGrowth(t *testing.T, store VoterStore, from, size int) int64 {
+	ctx := context.Background()
+
+	batch := make([]Voter, 0, 1000)
+	for id := from + 1; id <= size; id++ {
+		batch = append(batch, *NewVoter(uint(id), "Scan", "Test"))
+		if len(batch) == cap(batch) || id == size {
+			results, err := store.AddVoters(ctx, batch, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, err := range results {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			batch = batch[:0]
+		}
+	}
+	batch = nil
+
+	base := liveHeap()
+	peak := base
+	seen := 0
+	err := store.ScanVoters(ctx, VoterQuery{}, func(voter Voter) error {
+		seen++
+		if seen%(size/10) == 0 {
+			if heap := liveHeap(); heap > peak {
+				peak = heap
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen < size {
+		t.Fatalf("the scan went through %d voters, expected %d", seen, size)
+	}
+	return int64(peak - base)
+}
+
+// liveHeap is the heap still in use once the garbage is collected
+func liveHeap() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
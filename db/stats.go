@@ -0,0 +1,113 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	BucketHour = "hour"
+	BucketDay  = "day"
+)
+
+// PollStats is the participation in a single poll.  Participants counts
+// each voter once, Votes counts every entry in the histories (they only
+// differ for polls that allow multiple votes).  Turnout is Participants
+// as a percentage of all registered voters.
+type PollStats struct {
+	PollID       uint    `json:"pollid"`
+	Participants int     `json:"participants"`
+	Votes        int     `json:"votes"`
+	Turnout      float64 `json:"turnout"`
+}
+
+// VoteBucket is one bar of a votes over time histogram
+type VoteBucket struct {
+	Start time.Time `json:"start"`
+	Votes int       `json:"votes"`
+}
+
+// PollReport is the detailed breakdown of a single poll.  FirstTime are
+// the participants for whom this poll is the earliest vote in their
+// history, everybody else is Returning.
+type PollReport struct {
+	PollStats
+	Registered int          `json:"registered"`
+	FirstTime  int          `json:"firsttime"`
+	Returning  int          `json:"returning"`
+	Bucket     string       `json:"bucket"`
+	Histogram  []VoteBucket `json:"histogram"`
+}
+
+// VoterActivity is how many votes a voter has cast
+type VoterActivity struct {
+	VoterID   uint   `json:"id"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	Votes     int    `json:"votes"`
+}
+
+// truncateVote returns the start of the histogram bucket a vote falls in
+func truncateVote(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	if bucket == BucketHour {
+		return t.Truncate(time.Hour)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// validBucket checks the histogram bucket, "" means day
+func validBucket(bucket string) (string, error) {
+	switch bucket {
+	case "", BucketDay:
+		return BucketDay, nil
+	case BucketHour:
+		return BucketHour, nil
+	default:
+		return "", ErrInvalidQuery
+	}
+}
+
+func turnout(participants, registered int) float64 {
+	if registered == 0 {
+		return 0
+	}
+	return float64(participants) * 100 / float64(registered)
+}
+
+// isFirstVoteIn reports whether pollId holds the earliest vote in the
+// history.  Ties go to the poll that appears first in the history.
+func isFirstVoteIn(history []VoterPoll, pollId uint) bool {
+	var first *VoterPoll
+	for i := range history {
+		if first == nil || history[i].VoteDate.Before(first.VoteDate) {
+			first = &history[i]
+		}
+	}
+	return first != nil && first.PollID == pollId
+}
+
+// histogram turns bucket counts into a sorted slice
+func histogram(counts map[time.Time]int) []VoteBucket {
+	buckets := make([]VoteBucket, 0, len(counts))
+	for start, n := range counts {
+		buckets = append(buckets, VoteBucket{Start: start, Votes: n})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+	return buckets
+}
+
+// sortPollStats orders by poll id so the output is stable
+func sortPollStats(stats []PollStats) {
+	sort.Slice(stats, func(i, j int) bool { return stats[i].PollID < stats[j].PollID })
+}
+
+// sortActivity orders by votes, most active first, ties by voter id
+func sortActivity(a []VoterActivity) {
+	sort.Slice(a, func(i, j int) bool {
+		if a[i].Votes != a[j].Votes {
+			return a[i].Votes > a[j].Votes
+		}
+		return a[i].VoterID < a[j].VoterID
+	})
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// TestTopVoters checks the most active voters come first, ties by id
+// even when the tie is split by the cut, and that voters who never voted
+// are not listed at all
+func TestTopVoters(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store VoterStore) {
+		ctx := context.Background()
+
+		poll := Poll{PollID: 1, Title: "Top voters", Status: PollOpen, VotePolicy: PolicyMultiple}
+		if err := store.AddPollResource(ctx, poll); err != nil {
+			t.Fatal(err)
+		}
+		votes := map[uint]int{1: 0, 2: 3, 3: 1, 4: 0, 5: 3, 6: 1}
+		for id, n := range votes {
+			if _, err := store.AddVoter(ctx, *NewVoter(id, "Top", "Voter")); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < n; i++ {
+				if err := store.AddVoterPollData(ctx, id, poll.PollID); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		for _, test := range []struct {
+			n    int
+			want []uint
+		}{
+			{n: 0, want: []uint{2, 5, 3, 6}},
+			{n: 10, want: []uint{2, 5, 3, 6}},
+			{n: 2, want: []uint{2, 5}},
+			{n: 1, want: []uint{2}},
+			{n: 3, want: []uint{2, 5, 3}},
+		} {
+			top, err := store.TopVoters(ctx, test.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]uint, len(top))
+			for i, a := range top {
+				got[i] = a.VoterID
+				if a.Votes != votes[a.VoterID] {
+					t.Errorf("voter %d: expected %d votes, found %d", a.VoterID, votes[a.VoterID], a.Votes)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("TopVoters(%d) = %v, expected %v", test.n, got, test.want)
+			}
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	StoreRedis  = "redis"
+	StoreMemory = "memory"
+	StoreFile   = "file"
+
+	DefaultStore = StoreRedis
+)
+
+// VoterStore is the set of operations the API needs from a storage
+// backend.  The redis backed VoterList is the production implementation,
+// MemoryVoterList keeps everything in process so the API can be run
+// without any infrastructure (handy for development and CI) and
+// FileVoterList persists to a JSON file for small single node setups.
+//
+// Every write of a voter moves its Revision on by one, the store sets it
+// whatever the caller passed in.  A write that depends on the revision
+// checks it in the same atomic step as the write itself.
+type VoterStore interface {
+	//AddVoter stores a new voter under its own id and returns it as it
+	//was stored, revision included
+	AddVoter(ctx context.Context, voter Voter) (Voter, error)
+	//AddVoters adds a batch of voters in as few round trips as the
+	//backend allows.  There is one result per voter, nil if it was
+	//added and ErrVoterExists if the id is taken, also when it is
+	//taken by an earlier voter in the same batch.  With dryRun the
+	//checks are made but nothing is written.
+	AddVoters(ctx context.Context, voters []Voter, dryRun bool) ([]error, error)
+	//CreateVoter stores a new voter under the next free id and returns
+	//it with the id filled in, whatever id voter had is ignored.  Ids
+	//are never handed out twice, not even after the voter is deleted.
+	CreateVoter(ctx context.Context, voter Voter) (Voter, error)
+	UpdateVoter(ctx context.Context, voter Voter) error
+	//PatchVoter lets fn change a copy of the stored voter and writes
+	//back only what it changed, the updated voter is returned.  An
+	//error from fn is returned as is and nothing is written.  fn may be
+	//called more than once if the voter is written concurrently, it
+	//should not have side effects.
+	PatchVoter(ctx context.Context, id uint, fn func(voter *Voter) error) (Voter, error)
+	//DeleteVoter removes the voter if it is still at revision, or
+	//whatever revision it is at when revision is 0.  Otherwise nothing
+	//happens and ErrRevisionMismatch is returned.
+	DeleteVoter(ctx context.Context, id uint, revision uint64) error
+	DeleteAll(ctx context.Context) error
+	GetSingleVoterResource(ctx context.Context, id uint) (Voter, error)
+	GetAllVoters(ctx context.Context) ([]Voter, error)
+	ListVoters(ctx context.Context, q VoterQuery) (VoterPage, error)
+	//ScanVoters calls fn for every voter that matches the filters of
+	//q without holding them all in memory, Limit, Cursor and Sort are
+	//ignored and the order is up to the backend.  Redis may hand out
+	//the odd voter twice, see VoterList.ScanVoters.  It is not bound by
+	//a timeout, cancel ctx to stop it.
+	ScanVoters(ctx context.Context, q VoterQuery, fn func(voter Voter) error) error
+	GetVoterHistory(ctx context.Context, id uint) ([]VoterPoll, error)
+	GetVoterPollData(ctx context.Context, voterId uint, pollId uint) (*VoterPoll, error)
+	AddVoterPollData(ctx context.Context, voterId uint, pollId uint) error
+	DeletePoll(ctx context.Context, voterId uint, pollId uint) error
+
+	SetVotingRules(rules VotingRules)
+
+	//Ping checks the backend is reachable and usable, it backs the
+	//readiness probe
+	Ping(ctx context.Context) error
+
+	//Close releases the connections held by the backend, it is called
+	//once on shutdown after the last request has finished
+	Close() error
+
+	//The reverse index from polls to the voters that voted in them
+	GetPollVoters(ctx context.Context, pollId uint, limit int, cursor string) (VoterPage, error)
+	CountPollVoters(ctx context.Context, pollId uint) (int, error)
+	RebuildPollIndex(ctx context.Context) error
+
+	//The version of the stored data, the last migration that was
+	//applied to it, see migrate.go
+	SchemaVersion(ctx context.Context) (int, error)
+	SetSchemaVersion(ctx context.Context, version int) error
+
+	//Aggregates over the vote histories, see stats.go
+	PollStatistics(ctx context.Context) ([]PollStats, error)
+	PollReport(ctx context.Context, pollId uint, bucket string) (PollReport, error)
+	//TopVoters returns the n voters with the most votes, voters who have
+	//not voted are left out
+	TopVoters(ctx context.Context, n int) ([]VoterActivity, error)
+	VoterCounts(ctx context.Context) (voters int, votes int, err error)
+
+	PollStore
+	IdempotencyStore
+}
+
+// PollStore manages the poll definitions, they live in the same backend
+// as the voters so a vote can be checked against its poll
+type PollStore interface {
+	AddPollResource(ctx context.Context, poll Poll) error
+	UpdatePollResource(ctx context.Context, poll Poll) error
+	DeletePollResource(ctx context.Context, id uint) error
+	GetPollResource(ctx context.Context, id uint) (Poll, error)
+	GetAllPolls(ctx context.Context) ([]Poll, error)
+}
+
+// Make sure all the backends keep satisfying the interface
+var (
+	_ VoterStore = (*VoterList)(nil)
+	_ VoterStore = (*MemoryVoterList)(nil)
+	_ VoterStore = (*FileVoterList)(nil)
+)
+
+// StoreOptions picks and configures a storage backend
+type StoreOptions struct {
+	//Backend is one of the Store constants, empty means DefaultStore
+	Backend string
+	//Redis is used by the redis backend
+	Redis RedisOptions
+	//DataDir is where the file backend keeps its JSON files
+	DataDir string
+}
+
+// NewVoterStore returns the storage backend described by opts
+func NewVoterStore(opts StoreOptions) (VoterStore, error) {
+	backend := opts.Backend
+	if backend == "" {
+		backend = DefaultStore
+	}
+
+	switch backend {
+	case StoreRedis:
+		return NewVoterList(opts.Redis)
+	case StoreMemory:
+		return NewMemoryVoterList(), nil
+	case StoreFile:
+		return NewWithFileInstance(opts.DataDir)
+	default:
+		return nil, fmt.Errorf("unknown voter store %q", backend)
+	}
+}
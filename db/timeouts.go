@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Timeouts bound how long a single redis backed operation may take.  The
+// deadline is added to the context the caller passes in, so whichever
+// comes first, the caller cancelling (for the API, the client going
+// away) or the deadline, stops the operation.  A zero value means no
+// deadline of our own.
+type Timeouts struct {
+	//Lookups of a single document or key
+	Read time.Duration
+	//Writes, including every retry of a WATCH/MULTI transaction
+	Write time.Duration
+	//Anything that walks the keyspace: listings, statistics, DeleteAll
+	Scan time.Duration
+}
+
+// DefaultTimeouts are the deadlines used unless configured otherwise
+var DefaultTimeouts = Timeouts{
+	Read:  2 * time.Second,
+	Write: 5 * time.Second,
+	Scan:  30 * time.Second,
+}
+
+// withTimeout bounds ctx by d, the cancel func must always be called
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
@@ -0,0 +1,240 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// The rules a voter has to follow are declared with validate tags on
+// Voter and VoterPoll.  Everything that needs more than the voter itself
+// to decide, the poll windows and repeat votes, is checked by
+// VoterValidator.  Both the API and the bulk import go through
+// VoterValidator so a voter is held to the same rules whichever way it
+// comes in.  Ids stop at 2147483647 since the URLs parse them as 32 bit
+// signed integers.
+
+// VoteDateSkew is how far in the future a vote date may be, to allow for
+// clients whose clock is slightly ahead
+const VoteDateSkew = time.Minute
+
+// personName allows letters in any script, combining marks, and the
+// spaces, hyphens, apostrophes and periods found in real names
+var personName = regexp.MustCompile(`^[\p{L}\p{M}][\p{L}\p{M} '.\-]*$`)
+
+var validate = newValidate()
+
+func newValidate() *validator.Validate {
+	v := validator.New()
+	//Report fields by their JSON names, the ones the client sent
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	_ = v.RegisterValidation("personname", func(fl validator.FieldLevel) bool {
+		return personName.MatchString(fl.Field().String())
+	})
+	_ = v.RegisterValidation("date", func(fl validator.FieldLevel) bool {
+		_, ok := Date(fl.Field().String()).Time()
+		return ok
+	})
+	//notfuture takes a time.Time or a Date, a Date that is not valid is
+	//left to the date rule
+	_ = v.RegisterValidation("notfuture", func(fl validator.FieldLevel) bool {
+		switch value := fl.Field().Interface().(type) {
+		case time.Time:
+			return !value.After(time.Now().Add(VoteDateSkew))
+		case Date:
+			t, ok := value.Time()
+			return !ok || !t.After(time.Now())
+		}
+		return false
+	})
+	return v
+}
+
+// FieldError is one rule a voter breaks, Field is the JSON path of the
+// offending field, e.g. votehistory[1].votedate
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every rule a voter breaks, not just the first.
+// It matches ErrInvalidVoter with errors.Is.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		problems[i] = f.Field + " " + f.Message
+	}
+	return strings.Join(problems, ", ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidVoter
+}
+
+// VoterValidator checks voters against the rules declared on Voter and
+// VoterPoll, and their votes against the polls they were cast in.  Only
+// the polls that turn up in a vote history are read, each of them once,
+// so one validator can check a whole import without going back to the
+// store for every voter.  It is not safe for concurrent use.
+type VoterValidator struct {
+	store PollStore
+	rules VotingRules
+
+	//poll id -> the poll, nil if there is no such poll
+	polls map[uint]*Poll
+}
+
+// NewVoterValidator reads polls from store as they are needed, rules are
+// the configured vote policies
+func NewVoterValidator(store PollStore, rules VotingRules) *VoterValidator {
+	return &VoterValidator{store: store, rules: rules, polls: make(map[uint]*Poll)}
+}
+
+// poll returns the poll with the given id, nil if it was never defined
+func (vv *VoterValidator) poll(ctx context.Context, pollId uint) (*Poll, error) {
+	if poll, ok := vv.polls[pollId]; ok {
+		return poll, nil
+	}
+	poll, err := vv.store.GetPollResource(ctx, pollId)
+	if errors.Is(err, ErrPollNotFound) {
+		vv.polls[pollId] = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	vv.polls[pollId] = &poll
+	return &poll, nil
+}
+
+// Validate returns a *ValidationError with every rule voter breaks, or
+// nil.  On top of the declared rules a voter cannot register before
+// they were born, a vote has to fall inside the window of its poll, and
+// a poll can only be in the history more than once if its policy is
+// multiple.  Votes in polls that were never defined are only held to
+// the declared rules, there is no window to check them against.  Any
+// other error means the polls could not be read.
+func (vv *VoterValidator) Validate(ctx context.Context, voter Voter) error {
+	var fields []FieldError
+
+	var errs validator.ValidationErrors
+	if err := validate.Struct(voter); errors.As(err, &errs) {
+		fields = append(fields, ValidatorFieldErrors(errs)...)
+	} else if err != nil {
+		return err
+	}
+
+	born, bornOk := voter.DateOfBirth.Time()
+	registered, registeredOk := voter.RegistrationDate.Time()
+	if bornOk && registeredOk && registered.Before(born) {
+		fields = append(fields, FieldError{Field: "registrationdate", Message: "cannot be before the date of birth"})
+	}
+
+	seen := make(map[uint]bool, len(voter.VoteHistory))
+	for i, vote := range voter.VoteHistory {
+		path := fmt.Sprintf("votehistory[%d]", i)
+		if vote.PollID == 0 {
+			continue
+		}
+		poll, err := vv.poll(ctx, vote.PollID)
+		if err != nil {
+			return err
+		}
+
+		if seen[vote.PollID] && vv.policyFor(poll, vote.PollID) != PolicyMultiple {
+			fields = append(fields, FieldError{Field: path + ".pollid",
+				Message: fmt.Sprintf("poll %d is already in the vote history", vote.PollID)})
+		}
+		seen[vote.PollID] = true
+
+		if poll == nil || vote.VoteDate.IsZero() {
+			continue
+		}
+		if poll.OpensAt != nil && vote.VoteDate.Before(*poll.OpensAt) {
+			fields = append(fields, FieldError{Field: path + ".votedate",
+				Message: fmt.Sprintf("is before poll %d opened at %s", poll.PollID, poll.OpensAt.Format(time.RFC3339))})
+		}
+		if poll.ClosesAt != nil && !vote.VoteDate.Before(*poll.ClosesAt) {
+			fields = append(fields, FieldError{Field: path + ".votedate",
+				Message: fmt.Sprintf("is after poll %d closed at %s", poll.PollID, poll.ClosesAt.Format(time.RFC3339))})
+		}
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// policyFor is the poll's own policy, or the configured one, poll is
+// nil if it was never defined
+func (vv *VoterValidator) policyFor(poll *Poll, pollId uint) VotePolicy {
+	if poll != nil && poll.VotePolicy != "" {
+		return poll.VotePolicy
+	}
+	return vv.rules.PolicyFor(pollId)
+}
+
+// ValidatorFieldErrors describes the failed rules in errs field by
+// field, for the rules declared here as well as the ones gin checks when
+// it binds a request
+func ValidatorFieldErrors(errs validator.ValidationErrors) []FieldError {
+	fields := make([]FieldError, 0, len(errs))
+	for _, fe := range errs {
+		fields = append(fields, FieldError{Field: fieldPath(fe.Namespace()), Message: ruleMessage(fe)})
+	}
+	return fields
+}
+
+// fieldPath drops the struct name from a validator namespace,
+// Voter.votehistory[0].pollid becomes votehistory[0].pollid
+func fieldPath(ns string) string {
+	if _, path, ok := strings.Cut(ns, "."); ok {
+		return path
+	}
+	return ns
+}
+
+// ruleMessage says in words what a failed validate tag asks for
+func ruleMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "max":
+		if fe.Kind() == reflect.String {
+			return "must be at most " + fe.Param() + " characters"
+		}
+		return "must be at most " + fe.Param()
+	case "personname":
+		return "may only contain letters, spaces, hyphens, apostrophes and periods, starting with a letter"
+	case "notfuture":
+		return "cannot be in the future"
+	case "date":
+		return "must be a date like " + DateLayout
+	case "email":
+		return "must be an email address"
+	case "e164":
+		return "must be a phone number in E.164 format, e.g. +12155550123"
+	default:
+		if fe.Param() != "" {
+			return fmt.Sprintf("failed the %s=%s rule", fe.Tag(), fe.Param())
+		}
+		return fmt.Sprintf("failed the %s rule", fe.Tag())
+	}
+}
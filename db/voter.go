@@ -7,33 +7,60 @@ package db
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"math/rand"
+	"strconv"
+	"strings"
 	"time"
 
+	"drexel.edu/todo/logging"
 	"github.com/go-redis/redis/v8"
 	"github.com/nitishm/go-rejson/v4"
+	"github.com/nitishm/go-rejson/v4/rjs"
 )
 
 const (
 	RedisNilError        = "redis: nil"
 	RedisDefaultLocation = "0.0.0.0:6379"
 	RedisKeyPrefix       = "voter:"
+	RedisPollKeyPrefix   = "poll:"
+	RedisPollIndexPrefix = "pollvoters:"
+	RedisSchemaKey       = "meta:schemaversion"
+	RedisVoterIDKey      = "meta:lastvoterid"
+	RedisScanCount       = 100
+	RedisMaxRetries      = 50
+	RedisRetryMaxPause   = 20 * time.Millisecond
+	RedisJSONModule      = "ReJSON"
 )
 
 type cache struct {
 	cacheClient *redis.Client
-	jsonHelper  *rejson.Handler
-	context     context.Context
+	keyPrefix   string
 }
 
-// ToDoItem is the struct that represents a single ToDo item
-type voterPoll struct {
-	PollID   uint      `json:"pollid"`
-	VoteDate time.Time `json:"votedate"`
+// key puts the configured prefix in front of a key, every key the
+// VoterList touches has to go through here
+func (c *cache) key(k string) string {
+	return c.keyPrefix + k
+}
+
+// json returns a ReJSON helper for the redis connection.  The helper
+// binds the context it is created with to every command it sends, so
+// each call gets one bound to the context of the request it serves.
+func (c *cache) json(ctx context.Context) *rejson.Handler {
+	jsonHelper := rejson.NewReJSONHandler()
+	jsonHelper.SetGoRedisClientWithContext(ctx, c.cacheClient)
+	return jsonHelper
+}
+
+// VoterPoll is a single entry in a voters history, the poll they voted
+// in and when.  The poll itself is described by a Poll.
+type VoterPoll struct {
+	PollID   uint      `json:"pollid" validate:"required,max=2147483647"`
+	VoteDate time.Time `json:"votedate" validate:"required,notfuture"`
 }
 
 // VoterList is a type alias for a map of Voters.  The key
@@ -41,10 +68,28 @@ type voterPoll struct {
 // type DbMap map[int]ToDoItem
 
 type Voter struct {
-	VoterId     uint        `json:"id"`
-	FirstName   string      `json:"firstname"`
-	LastName    string      `json:"lastname"`
-	VoteHistory []voterPoll `json:"votehistory"`
+	VoterId     uint        `json:"id" validate:"required,max=2147483647"`
+	FirstName   string      `json:"firstname" validate:"required,max=64,personname"`
+	LastName    string      `json:"lastname" validate:"required,max=64,personname"`
+	VoteHistory []VoterPoll `json:"votehistory" validate:"dive"`
+
+	//The profile is optional, voters stored before it existed simply
+	//do not have one.  Phone numbers are E.164, +12155550123.
+	DateOfBirth      Date     `json:"dateofbirth,omitempty" validate:"omitempty,date,notfuture"`
+	Address          *Address `json:"address,omitempty"`
+	District         string   `json:"district,omitempty" validate:"max=32"`
+	Precinct         string   `json:"precinct,omitempty" validate:"max=32"`
+	Email            string   `json:"email,omitempty" validate:"omitempty,max=254,email"`
+	Phone            string   `json:"phone,omitempty" validate:"omitempty,e164"`
+	RegistrationDate Date     `json:"registrationdate,omitempty" validate:"omitempty,date,notfuture"`
+
+	//Earlier votes that were replaced in polls that allow revoting
+	VoteAudit []VoteChange `json:"voteaudit,omitempty"`
+
+	//Revision is set by the store, it is 1 when the voter is created and
+	//goes up by one with every write.  Whatever the caller puts in it
+	//is ignored.
+	Revision uint64 `json:"revision,omitempty"`
 }
 
 type VoterList struct {
@@ -52,6 +97,9 @@ type VoterList struct {
 
 	//Redis cache connections
 	cache
+
+	votingRules
+	timeouts Timeouts
 }
 
 //------------------------------------------------------------
@@ -60,71 +108,155 @@ type VoterList struct {
 
 func NewVoter(id uint, fn, ln string) *Voter {
 	return &Voter{
+		VoterId:     id,
 		FirstName:   fn,
 		LastName:    ln,
-		VoteHistory: []voterPoll{},
+		VoteHistory: []VoterPoll{},
 	}
 }
 
-func (v *Voter) AddPoll(pollID uint) {
-	v.VoteHistory = append(v.VoteHistory, voterPoll{PollID: pollID, VoteDate: time.Now()})
+// nextRevision is the revision of a voter written over old, nil if the
+// voter is new
+func nextRevision(old *Voter) uint64 {
+	if old == nil {
+		return 1
+	}
+	return old.Revision + 1
+}
+
+// CheckRevision returns ErrRevisionMismatch unless revision is 0, which
+// means any, or the revision of v
+func (v *Voter) CheckRevision(revision uint64) error {
+	if revision != 0 && v.Revision != revision {
+		return fmt.Errorf("%w: voter %d is at revision %d, not %d",
+			ErrRevisionMismatch, v.VoterId, v.Revision, revision)
+	}
+	return nil
 }
 
-func NewVoterList() (*VoterList, error) {
+func (v *Voter) AddPoll(pollID uint) {
+	v.VoteHistory = append(v.VoteHistory, VoterPoll{PollID: pollID, VoteDate: time.Now()})
+}
 
-	//We will use an override if the REDIS_URL is provided as an environment
-	//variable, which is the preferred way to wire up a docker container
-	redisUrl := os.Getenv("REDIS_URL")
-	//This handles the default condition
-	if redisUrl == "" {
-		redisUrl = RedisDefaultLocation
+// removePoll drops the first entry for pollID from the history, it
+// reports whether there was one to remove
+func (v *Voter) removePoll(pollID uint) bool {
+	for j := 0; j < len(v.VoteHistory); j++ {
+		if v.VoteHistory[j].PollID == pollID {
+			v.VoteHistory = append(v.VoteHistory[:j], v.VoteHistory[j+1:]...)
+			return true
+		}
 	}
-	return NewWithCacheInstance(redisUrl)
+	return false
+}
 
+// RedisOptions says how to reach redis and how to lay out the keys.  The
+// zero value talks to RedisDefaultLocation without a deadline on any
+// operation.
+type RedisOptions struct {
+	//Addr is either host:port or a redis:// or rediss:// URL, the URL
+	//may carry the password and db number as well
+	Addr     string
+	Password string
+	DB       int
+	//TLS turns on TLS for a host:port address, a rediss:// URL always
+	//uses it
+	TLS bool
+	//PoolSize is the maximum number of connections, 0 lets go-redis
+	//pick (10 per CPU)
+	PoolSize int
+	//KeyPrefix is put in front of every key, so several deployments
+	//can share one redis
+	KeyPrefix string
+
+	Timeouts Timeouts
 }
 
-// NewWithCacheInstance is a constructor function that returns a pointer to a new
-// ToDo struct.  It accepts a string that represents the location of the redis
-// cache.
-func NewWithCacheInstance(location string) (*VoterList, error) {
+// clientOptions turns the options into the go-redis ones
+func (o RedisOptions) clientOptions() (*redis.Options, error) {
+	addr := o.Addr
+	if addr == "" {
+		addr = RedisDefaultLocation
+	}
 
-	//Connect to redis.  Other options can be provided, but the
-	//defaults are OK
-	client := redis.NewClient(&redis.Options{
-		Addr: location,
-	})
+	if strings.HasPrefix(addr, "redis://") || strings.HasPrefix(addr, "rediss://") {
+		opts, err := redis.ParseURL(addr)
+		if err != nil {
+			return nil, err
+		}
+		//Anything set explicitly wins over the URL
+		if o.Password != "" {
+			opts.Password = o.Password
+		}
+		if o.DB != 0 {
+			opts.DB = o.DB
+		}
+		if o.TLS && opts.TLSConfig == nil {
+			opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, ServerName: strings.Split(opts.Addr, ":")[0]}
+		}
+		opts.PoolSize = o.PoolSize
+		return opts, nil
+	}
 
-	//We use this context to coordinate betwen our go code and
-	//the redis operaitons
-	ctx := context.Background()
+	opts := &redis.Options{
+		Addr:     addr,
+		Password: o.Password,
+		DB:       o.DB,
+		PoolSize: o.PoolSize,
+	}
+	if o.TLS {
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, ServerName: strings.Split(addr, ":")[0]}
+	}
+	return opts, nil
+}
+
+// NewVoterList connects to redis, it fails if redis cannot be reached
+func NewVoterList(o RedisOptions) (*VoterList, error) {
+
+	//Connect to redis
+	opts, err := o.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
 
 	//This is the reccomended way to ensure that our redis connection
 	//is working
-	err := client.Ping(ctx).Err()
+	ctx := context.Background()
+	err = client.Ping(ctx).Err()
 	if err != nil {
-		log.Println("Error connecting to redis" + err.Error())
-		return nil, err
+		logging.FromContext(ctx).Error("cannot connect to redis", "addr", opts.Addr, "error", err)
+		client.Close()
+		return nil, storageError(err)
 	}
 
+	//Every command is logged at debug level with the id of the request
+	//that caused it, see redisLogger
+	client.AddHook(redisLogger{})
+
 	//By default, redis manages keys and values, where the values
 	//are either strings, sets, maps, etc.  Redis has an extension
 	//module called ReJSON that allows us to store JSON objects
-	//however, we need a companion library in order to work with it
-	//Below we create an instance of the JSON helper and associate
-	//it with our redis connnection
-	jsonHelper := rejson.NewReJSONHandler()
-	jsonHelper.SetGoRedisClientWithContext(ctx, client)
+	//however, we need a companion library in order to work with it,
+	//see cache.json
 
 	//Return a pointer to a new ToDo struct
 	return &VoterList{
 		cache: cache{
 			cacheClient: client,
-			jsonHelper:  jsonHelper,
-			context:     ctx,
+			keyPrefix:   o.KeyPrefix,
 		},
+		timeouts: o.Timeouts,
 	}, nil
 }
 
+// NewWithCacheInstance is a constructor function that returns a pointer to a new
+// VoterList.  It accepts a string that represents the location of the redis
+// cache, everything else is left at the defaults.
+func NewWithCacheInstance(location string) (*VoterList, error) {
+	return NewVoterList(RedisOptions{Addr: location, Timeouts: DefaultTimeouts})
+}
+
 //------------------------------------------------------------
 // REDIS HELPERS
 //------------------------------------------------------------
@@ -134,6 +266,26 @@ func isRedisNilError(err error) bool {
 	return errors.Is(err, redis.Nil) || err.Error() == RedisNilError
 }
 
+// retryPause waits before the next attempt at a transaction that lost
+// a WATCH race.  The wait is random and grows with every attempt, so
+// writers piling onto the same key spread out instead of colliding
+// again in lock step.
+func retryPause(ctx context.Context, attempt int) error {
+	limit := time.Duration(attempt) * time.Millisecond
+	if limit > RedisRetryMaxPause {
+		limit = RedisRetryMaxPause
+	}
+	t := time.NewTimer(time.Duration(rand.Int63n(int64(limit))) + 1)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return storageError(ctx.Err())
+	case <-t.C:
+		return nil
+	}
+}
+
 // In redis, our keys will be strings, they will look like
 // todo:<number>.  This function will take an integer and
 // return a string that can be used as a key in redis
@@ -141,120 +293,547 @@ func redisKeyFromId(id int) string {
 	return fmt.Sprintf("%s%d", RedisKeyPrefix, id)
 }
 
-// Helper to return a ToDoItem from redis provided a key
-func (v *VoterList) getItemFromRedis(key string, item *Voter) error {
+// Helper to return a Voter from redis provided a key
+func (v *VoterList) getItemFromRedis(ctx context.Context, key string, item *Voter) error {
+	return getJSON(ctx, v, key, item, ErrVoterNotFound)
+}
+
+// getJSON loads the JSON document stored at key into item, notFound is
+// returned if there is no such key
+func getJSON[T any](ctx context.Context, v *VoterList, key string, item *T, notFound error) error {
 
 	//Lets query redis for the item, note we can return parts of the
 	//json structure, the second parameter "." means return the entire
 	//json structure
-	itemObject, err := v.jsonHelper.JSONGet(key, ".")
+	itemObject, err := v.json(ctx).JSONGet(key, ".")
 	if err != nil {
-		return err
+		if isRedisNilError(err) {
+			return notFound
+		}
+		return storageError(err)
 	}
 
 	//JSONGet returns an "any" object, or empty interface,
 	//we need to convert it to a byte array, which is the
 	//underlying type of the object, then we can unmarshal
-	//it into our ToDoItem struct
-	err = json.Unmarshal(itemObject.([]byte), item)
-	if err != nil {
-		return nil
+	//it into our struct
+	return json.Unmarshal(itemObject.([]byte), item)
+}
+
+// scanKeys walks every key starting with prefix using SCAN, handing them
+// to fn a batch at a time.  Unlike KEYS this never blocks redis for long,
+// at the cost of possibly seeing a key twice if the keyspace is rehashed
+// mid scan.
+func (v *VoterList) scanKeys(ctx context.Context, prefix string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := v.cacheClient.Scan(ctx, cursor, prefix+"*", RedisScanCount).Result()
+		if err != nil {
+			return storageError(err)
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
 	}
+}
 
-	return nil
+// scanVoters calls fn for every voter, see scanJSON
+func (v *VoterList) scanVoters(ctx context.Context, fn func(voter Voter) error) error {
+	return scanJSON(ctx, v, v.key(RedisKeyPrefix), fn)
 }
 
-//------------------------------------------------------------
-// THESE ARE THE PUBLIC FUNCTIONS THAT SUPPORT OUR VOTER APP
-//------------------------------------------------------------
+// scanJSON loads every document under prefix a batch at a time with
+// JSON.MGET and calls fn for each one.  Nothing is kept from one batch
+// to the next so memory does not grow with the number of documents, the
+// price is that a key SCAN hands out again in a later batch (it can when
+// redis resizes its table mid scan) reaches fn twice.  Callers that
+// cannot take that drop the repeat themselves, see GetAllVoters.
+func scanJSON[T any](ctx context.Context, v *VoterList, prefix string, fn func(item T) error) error {
+	return v.scanKeys(ctx, prefix, func(keys []string) error {
+		res, err := v.json(ctx).JSONMGet(".", keys...)
+		if err != nil {
+			return storageError(err)
+		}
 
-func (lst *VoterList) AddVoter(voter Voter) error {
+		seen := make(map[string]bool, len(keys))
+		for i, raw := range res.([]interface{}) {
+			//The key may have been deleted between SCAN and MGET, and
+			//SCAN may hand out the same key twice in one batch
+			if raw == nil || seen[keys[i]] {
+				continue
+			}
+			seen[keys[i]] = true
+
+			var item T
+			if err := json.Unmarshal(raw.([]byte), &item); err != nil {
+				return err
+			}
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
 
-	// lst.Voters[voter.VoterId] = voter
-	// return nil
+// modifyVoter is an optimistic read-modify-write of a single voter, see
+// modifyJSON.  If the voter does not exist fn is handed a new voter with
+// just the id set.  The poll index and statistics are updated in the
+// same transaction, and the revision is moved on after fn is done.  Any
+// keys in watch are WATCHed along with the voter, see modifyJSON.
+func (v *VoterList) modifyVoter(ctx context.Context, id uint, fn func(voter *Voter, found bool) error, watch ...string) error {
+	return modifyJSON(ctx, v, v.key(redisKeyFromId(int(id))), Voter{VoterId: id}, func(voter *Voter, found bool) error {
+		revision := voter.Revision
+		if err := fn(voter, found); err != nil {
+			return err
+		}
+		voter.Revision = revision + 1
+		return nil
+	}, v.queueVoterChanges, watch...)
+}
 
-	//Before we add an item to the DB, lets make sure
-	//it does not exist, if it does, return an error
+// modifyJSON is an optimistic read-modify-write of a single JSON document.
+// The key is WATCHed while fn changes the document, and the new version
+// is written in a MULTI/EXEC block, if anybody else wrote the key in the
+// meantime redis aborts the EXEC and we start over with a fresh copy.
+// fn is told whether the document already existed, if not it is handed
+// a copy of init.  Any error from fn is returned as is and nothing is
+// written.  onWrite, if not nil, can queue more commands in the same
+// MULTI, it gets the old document (nil if there was none) and the new.
+// The keys in watch are WATCHed too, fn can read them knowing the write
+// only goes through if none of them changed in the meantime either.
+func modifyJSON[T any](ctx context.Context, v *VoterList, key string, init T, fn func(item *T, found bool) error,
+	onWrite func(ctx context.Context, pipe redis.Pipeliner, old *T, updated *T), watch ...string) error {
+	txf := func(tx *redis.Tx) error {
+		item := init
+		found := true
+		var old *T
+
+		get := redis.NewStringCmd(ctx, "JSON.GET", key, ".")
+		_ = tx.Process(ctx, get)
+		raw, err := get.Result()
+		switch {
+		case err == nil:
+			if err := json.Unmarshal([]byte(raw), &item); err != nil {
+				return err
+			}
+			//a second copy, item is about to be changed by fn
+			old = new(T)
+			if err := json.Unmarshal([]byte(raw), old); err != nil {
+				return err
+			}
+		case isRedisNilError(err):
+			found = false
+		default:
+			return storageError(err)
+		}
+
+		if err := fn(&item, found); err != nil {
+			return err
+		}
 
-	redisKey := redisKeyFromId(int(voter.VoterId))
-	var existingVoter Voter
-	if err := lst.getItemFromRedis(redisKey, &existingVoter); err == nil {
-		return errors.New("voter already exists")
+		doc, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Do(ctx, "JSON.SET", key, ".", string(doc))
+			if onWrite != nil {
+				onWrite(ctx, pipe, old, &item)
+			}
+			return nil
+		})
+		return err
 	}
 
-	//Add item to database with JSON Set
-	if _, err := lst.jsonHelper.JSONSet(redisKey, ".", voter); err != nil {
+	for i := 0; i < RedisMaxRetries; i++ {
+		err := v.cacheClient.Watch(ctx, txf, append([]string{key}, watch...)...)
+		if errors.Is(err, redis.TxFailedErr) {
+			logging.FromContext(ctx).Debug("concurrent write, retrying", "key", key, "attempt", i+1)
+			if err := retryPause(ctx, i+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil && !isDomainError(err) {
+			return storageError(err)
+		}
 		return err
 	}
 
-	//If everything is ok, return nil for the error
-	return nil
+	return storageError(fmt.Errorf("%s changed %d times while updating it", key, RedisMaxRetries))
 }
 
-func (lst *VoterList) DeleteVoter(id uint) error {
+// AddHook installs a go-redis hook on the client, this is how the
+// metrics package times every redis command
+func (lst *VoterList) AddHook(hook redis.Hook) {
+	lst.cacheClient.AddHook(hook)
+}
+
+// Close closes the redis client and its connection pool
+func (lst *VoterList) Close() error {
+	return storageError(lst.cacheClient.Close())
+}
+
+// Ping checks redis answers and that the ReJSON module is loaded, every
+// voter operation depends on it
+func (lst *VoterList) Ping(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Read)
+	defer cancel()
+
+	if err := lst.cacheClient.Ping(ctx).Err(); err != nil {
+		return storageError(err)
+	}
 
-	pattern := redisKeyFromId(int(id))
-	numDeleted, err := lst.cacheClient.Del(lst.context, pattern).Result()
+	modules, err := lst.cacheClient.Do(ctx, "MODULE", "LIST").Slice()
 	if err != nil {
-		return err
+		return storageError(err)
 	}
-	if numDeleted == 0 {
-		return errors.New("attempted to delete non-existent item")
+	for _, m := range modules {
+		if moduleName(m) == RedisJSONModule {
+			return nil
+		}
 	}
+	return storageError(errors.New("redis does not have the ReJSON module loaded"))
+}
 
-	return nil
+// moduleName digs the name out of one MODULE LIST entry, which depending
+// on the protocol version is either a flat list of key/value pairs or a
+// map
+func moduleName(entry interface{}) string {
+	switch e := entry.(type) {
+	case []interface{}:
+		for i := 0; i+1 < len(e); i += 2 {
+			if k, _ := e[i].(string); k == "name" {
+				name, _ := e[i+1].(string)
+				return name
+			}
+		}
+	case map[interface{}]interface{}:
+		name, _ := e["name"].(string)
+		return name
+	}
+	return ""
 }
 
-func (lst *VoterList) DeleteAll() error {
-	pattern := RedisKeyPrefix + "*"
-	ks, _ := lst.cacheClient.Keys(lst.context, pattern).Result()
-	//Note delete can take a collection of keys.  In go we can
-	//expand a slice into individual arguments by using the ...
-	//operator
-	numDeleted, err := lst.cacheClient.Del(lst.context, ks...).Result()
+//------------------------------------------------------------
+// THESE ARE THE PUBLIC FUNCTIONS THAT SUPPORT OUR VOTER APP
+//------------------------------------------------------------
+
+func (lst *VoterList) AddVoter(ctx context.Context, voter Voter) (Voter, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Write)
+	defer cancel()
+
+	//Before we add an item to the DB, lets make sure
+	//it does not exist, if it does, return an error.  Doing
+	//this inside modifyVoter makes the check and the write
+	//one atomic step
+	err := lst.modifyVoter(ctx, voter.VoterId, func(existing *Voter, found bool) error {
+		if found {
+			return ErrVoterExists
+		}
+		*existing = voter
+		return nil
+	})
 	if err != nil {
+		return Voter{}, err
+	}
+	voter.Revision = nextRevision(nil)
+	return voter, nil
+}
+
+// AddVoters checks which of the voters exist with one pipeline of
+// EXISTS and writes the rest in one MULTI, with their poll index and
+// statistics.  All the keys are WATCHed so a voter created by somebody
+// else in between makes the whole batch start over.
+func (lst *VoterList) AddVoters(ctx context.Context, voters []Voter, dryRun bool) ([]error, error) {
+	if len(voters) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Write)
+	defer cancel()
+
+	keys := make([]string, len(voters))
+	for i, voter := range voters {
+		keys[i] = lst.key(redisKeyFromId(int(voter.VoterId)))
+	}
+
+	var results []error
+	txf := func(tx *redis.Tx) error {
+		results = make([]error, len(voters))
+
+		exists := make([]*redis.IntCmd, len(keys))
+		_, err := tx.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				exists[i] = pipe.Exists(ctx, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		seen := make(map[uint]bool, len(voters))
+		for i, voter := range voters {
+			if exists[i].Val() > 0 || seen[voter.VoterId] {
+				results[i] = ErrVoterExists
+			}
+			seen[voter.VoterId] = true
+		}
+		if dryRun {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i := range voters {
+				if results[i] != nil {
+					continue
+				}
+				voter := voters[i]
+				voter.Revision = nextRevision(nil)
+				doc, err := json.Marshal(voter)
+				if err != nil {
+					return err
+				}
+				pipe.Do(ctx, "JSON.SET", keys[i], ".", string(doc))
+				lst.queueVoterChanges(ctx, pipe, nil, &voter)
+			}
+			return nil
+		})
 		return err
 	}
 
-	if numDeleted != int64(len(ks)) {
-		return errors.New("one or more items could not be deleted")
+	for i := 0; i < RedisMaxRetries; i++ {
+		err := lst.cacheClient.Watch(ctx, txf, keys...)
+		if errors.Is(err, redis.TxFailedErr) {
+			logging.FromContext(ctx).Debug("concurrent write, retrying batch", "voters", len(voters), "attempt", i+1)
+			if err := retryPause(ctx, i+1); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, storageError(err)
+		}
+		return results, nil
 	}
 
-	return nil
+	return nil, storageError(fmt.Errorf("a batch of %d voters changed %d times while adding it", len(voters), RedisMaxRetries))
 }
 
-func (lst *VoterList) UpdateVoter(voter Voter) error {
+func (lst *VoterList) DeleteVoter(ctx context.Context, id uint, revision uint64) error {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Write)
+	defer cancel()
 
-	//Before we add an item to the DB, lets make sure
-	//it does not exist, if it does, return an error
-	redisKey := redisKeyFromId(int(voter.VoterId))
-	var existingItem Voter
-	if err := lst.getItemFromRedis(redisKey, &existingItem); err != nil {
-		return errors.New("item does not exist")
+	//The voter has to be read first to know which poll index entries
+	//to drop, WATCH makes sure it does not change in between
+	key := lst.key(redisKeyFromId(int(id)))
+	txf := func(tx *redis.Tx) error {
+		var voter Voter
+		if err := lst.getItemFromRedis(ctx, key, &voter); err != nil {
+			return err
+		}
+		if err := voter.CheckRevision(revision); err != nil {
+			return err
+		}
+
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			lst.queueVoterChanges(ctx, pipe, &voter, nil)
+			return nil
+		})
+		return err
 	}
 
-	//Add item to database with JSON Set.  Note there is no update
-	//functionality, so we just overwrite the existing item
-	if _, err := lst.jsonHelper.JSONSet(redisKey, ".", voter); err != nil {
+	for i := 0; i < RedisMaxRetries; i++ {
+		err := lst.cacheClient.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			logging.FromContext(ctx).Debug("concurrent write, retrying", "key", key, "attempt", i+1)
+			if err := retryPause(ctx, i+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil && !isDomainError(err) {
+			return storageError(err)
+		}
 		return err
 	}
 
-	//If everything is ok, return nil for the error
+	return storageError(fmt.Errorf("%s changed %d times while deleting it", key, RedisMaxRetries))
+}
+
+// DeleteAll removes every voter and with them the whole poll index and
+// the statistics
+func (lst *VoterList) DeleteAll(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Scan)
+	defer cancel()
+
+	for _, prefix := range []string{RedisKeyPrefix, RedisPollIndexPrefix, RedisStatsPrefix} {
+		err := lst.scanKeys(ctx, lst.key(prefix), func(ks []string) error {
+			//Note delete can take a collection of keys.  In go we can
+			//expand a slice into individual arguments by using the ...
+			//operator.  Keys that vanished since the SCAN, or were handed
+			//out twice, are simply not counted so a short count is fine.
+			if err := lst.cacheClient.Del(ctx, ks...).Err(); err != nil {
+				return storageError(err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (lst *VoterList) UpdateVoter(ctx context.Context, voter Voter) error {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Write)
+	defer cancel()
+
+	//The voter has to exist already, note there is no update
+	//functionality in ReJSON, so we just overwrite the existing item
+	return lst.modifyVoter(ctx, voter.VoterId, func(existing *Voter, found bool) error {
+		if !found {
+			return ErrVoterNotFound
+		}
+		*existing = voter
+		return nil
+	})
+}
+
+// PatchVoter is a read-modify-write like modifyVoter, except that only
+// the top level fields fn changed are written, each with a JSON.SET of
+// its own path, so a new last name does not rewrite the vote history.
+// Fields fn dropped (those marked omitempty) are deleted.  The revision
+// always changes, so there is always something to write.
+func (lst *VoterList) PatchVoter(ctx context.Context, id uint, fn func(voter *Voter) error) (Voter, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Write)
+	defer cancel()
+
+	key := lst.key(redisKeyFromId(int(id)))
+	var voter Voter
+	var fnErr error
+	txf := func(tx *redis.Tx) error {
+		get := redis.NewStringCmd(ctx, "JSON.GET", key, ".")
+		_ = tx.Process(ctx, get)
+		raw, err := get.Result()
+		if isRedisNilError(err) {
+			return ErrVoterNotFound
+		}
+		if err != nil {
+			return storageError(err)
+		}
+
+		var old Voter
+		if err := json.Unmarshal([]byte(raw), &old); err != nil {
+			return err
+		}
+		voter = Voter{}
+		if err := json.Unmarshal([]byte(raw), &voter); err != nil {
+			return err
+		}
+		if fnErr = fn(&voter); fnErr != nil {
+			return fnErr
+		}
+		voter.Revision = nextRevision(&old)
+
+		set, del, err := changedFields(&old, &voter)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for field, value := range set {
+				pipe.Do(ctx, "JSON.SET", key, "."+field, string(value))
+			}
+			for _, field := range del {
+				pipe.Do(ctx, "JSON.DEL", key, "."+field)
+			}
+			lst.queueVoterChanges(ctx, pipe, &old, &voter)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < RedisMaxRetries; i++ {
+		err := lst.cacheClient.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			logging.FromContext(ctx).Debug("concurrent write, retrying", "key", key, "attempt", i+1)
+			if err := retryPause(ctx, i+1); err != nil {
+				return Voter{}, err
+			}
+			continue
+		}
+		if fnErr != nil {
+			return Voter{}, fnErr
+		}
+		if err != nil && !isDomainError(err) {
+			return Voter{}, storageError(err)
+		}
+		if err != nil {
+			return Voter{}, err
+		}
+		return voter, nil
+	}
+
+	return Voter{}, storageError(fmt.Errorf("%s changed %d times while updating it", key, RedisMaxRetries))
+}
+
+// changedFields compares the JSON of two versions of a document field by
+// field.  set has the new value of every top level field that changed or
+// was added, del the fields that are gone.
+func changedFields(old, updated any) (map[string]json.RawMessage, []string, error) {
+	var before, after map[string]json.RawMessage
+	for _, doc := range []struct {
+		v   any
+		dst *map[string]json.RawMessage
+	}{{old, &before}, {updated, &after}} {
+		raw, err := json.Marshal(doc.v)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(raw, doc.dst); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	set := make(map[string]json.RawMessage)
+	for field, value := range after {
+		if prev, ok := before[field]; !ok || string(prev) != string(value) {
+			set[field] = value
+		}
+	}
+	var del []string
+	for field := range before {
+		if _, ok := after[field]; !ok {
+			del = append(del, field)
+		}
+	}
+	return set, del, nil
+}
+
 /*
 Get a single voter resource with voterID=:id including their entire voting history.
 POST version adds one to the "database"
 */
-func (lst *VoterList) GetSingleVoterResource(id uint) (Voter, error) {
+func (lst *VoterList) GetSingleVoterResource(ctx context.Context, id uint) (Voter, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Read)
+	defer cancel()
 
 	// Check if item exists before trying to get it
 	// this is a good practice, return an error if the
 	// item does not exist
 	var voter Voter
-	pattern := redisKeyFromId(int(id))
-	err := lst.getItemFromRedis(pattern, &voter)
+	pattern := lst.key(redisKeyFromId(int(id)))
+	err := lst.getItemFromRedis(ctx, pattern, &voter)
 	if err != nil {
 		return Voter{}, err
 	}
@@ -292,13 +871,15 @@ func (lst *VoterList) ChangeItemDoneStatus(id int, value bool) error {
 /*
 Gets JUST the voter history for the voter with VoterID = :id
 */
-func (lst *VoterList) GetVoterHistory(id uint) ([]voterPoll, error) {
+func (lst *VoterList) GetVoterHistory(ctx context.Context, id uint) ([]VoterPoll, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Read)
+	defer cancel()
 
 	var voter Voter
-	pattern := redisKeyFromId(int(id))
-	err := lst.getItemFromRedis(pattern, &voter)
+	pattern := lst.key(redisKeyFromId(int(id)))
+	err := lst.getItemFromRedis(ctx, pattern, &voter)
 	if err != nil {
-		return []voterPoll{}, err
+		return []VoterPoll{}, err
 	}
 
 	return voter.VoteHistory, nil
@@ -306,39 +887,135 @@ func (lst *VoterList) GetVoterHistory(id uint) ([]voterPoll, error) {
 }
 
 /*
-Get all voter resources including all voter history for each voter (note we will
-discuss the concept of "paging" later, for now you can ignore)
+Get all voter resources including all voter history for each voter.  Use
+ListVoters when the voter roll may be large, this loads every voter.
 */
-func (lst *VoterList) GetAllVoters() ([]Voter, error) {
+func (lst *VoterList) GetAllVoters(ctx context.Context) ([]Voter, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Scan)
+	defer cancel()
 
-	//Now that we have the DB loaded, lets crate a slice
+	//Now that we have the DB loaded, lets crate a slice.  Every voter
+	//is kept anyway, so the ids cost little and weed out SCAN repeats
 	var voterList []Voter
+	seen := make(map[uint]bool)
 
-	//Lets query redis for all of the items
-	pattern := RedisKeyPrefix + "*"
-	ks, _ := lst.cacheClient.Keys(lst.context, pattern).Result()
-	for _, key := range ks {
-		var voter Voter
-		err := lst.getItemFromRedis(key, &voter)
-		if err != nil {
-			return nil, err
+	err := lst.scanVoters(ctx, func(voter Voter) error {
+		if !seen[voter.VoterId] {
+			seen[voter.VoterId] = true
+			voterList = append(voterList, voter)
 		}
-		voterList = append(voterList, voter)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return voterList, nil
 }
 
+// ListVoters returns one page of voters matching the query.  The voters
+// are streamed out of redis with SCAN so a large voter roll never
+// blocks the server the way KEYS would, and only the page is kept, see
+// pager.
+func (lst *VoterList) ListVoters(ctx context.Context, q VoterQuery) (VoterPage, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Scan)
+	defer cancel()
+
+	p, err := newPager(q)
+	if err != nil {
+		return VoterPage{}, err
+	}
+
+	err = lst.scanVoters(ctx, func(voter Voter) error {
+		p.add(voter)
+		return nil
+	})
+	if err != nil {
+		return VoterPage{}, err
+	}
+
+	return p.page(), nil
+}
+
+// raiseVoterID moves the id counter up to ARGV[1] if it is behind, it is
+// never moved down so ids are not handed out twice even after a delete
+var raiseVoterID = `
+local last = tonumber(redis.call('GET', KEYS[1]) or '0')
+if last < tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return 0`
+
+// queueIDChanges keeps the id counter ahead of voters created with an id
+// of their own choosing, so CreateVoter does not run into them
+func (lst *VoterList) queueIDChanges(ctx context.Context, pipe redis.Pipeliner, old, updated *Voter) {
+	if old == nil && updated != nil {
+		pipe.Eval(ctx, raiseVoterID, []string{lst.key(RedisVoterIDKey)}, updated.VoterId)
+	}
+}
+
+// CreateVoter takes the next id from the RedisVoterIDKey counter.  The
+// counter is always at or past the highest id in use, but somebody can
+// pick the same id for themselves between the INCR and the write, then
+// the next id is tried.
+func (lst *VoterList) CreateVoter(ctx context.Context, voter Voter) (Voter, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Write)
+	defer cancel()
+
+	for i := 0; i < RedisMaxRetries; i++ {
+		id, err := lst.cacheClient.Incr(ctx, lst.key(RedisVoterIDKey)).Result()
+		if err != nil {
+			return Voter{}, storageError(err)
+		}
+		voter.VoterId = uint(id)
+
+		err = lst.modifyVoter(ctx, voter.VoterId, func(existing *Voter, found bool) error {
+			if found {
+				return ErrVoterExists
+			}
+			*existing = voter
+			return nil
+		})
+		if errors.Is(err, ErrVoterExists) {
+			logging.FromContext(ctx).Debug("voter id taken, trying the next one", "id", id)
+			continue
+		}
+		if err != nil {
+			return Voter{}, err
+		}
+		voter.Revision = nextRevision(nil)
+		return voter, nil
+	}
+
+	return Voter{}, storageError(fmt.Errorf("no free voter id after %d tries", RedisMaxRetries))
+}
+
+// ScanVoters walks the voters with SCAN, so they come in no particular
+// order, and filters them as they are loaded.  Memory stays flat however
+// many voters there are, which means a voter SCAN hands out twice is
+// passed to fn twice, see scanJSON.  That is rare and an export holding
+// a voter twice imports fine, the second copy is a duplicate.
+func (lst *VoterList) ScanVoters(ctx context.Context, q VoterQuery, fn func(voter Voter) error) error {
+	return lst.scanVoters(ctx, func(voter Voter) error {
+		if !q.Matches(voter) {
+			return nil
+		}
+		return fn(voter)
+	})
+}
+
 /*
 Gets JUST the single voter poll data with PollID = :id and VoterID = :id.
 */
-func (lst *VoterList) GetVoterPollData(voterId uint, pollId uint) (*voterPoll, error) {
+func (lst *VoterList) GetVoterPollData(ctx context.Context, voterId uint, pollId uint) (*VoterPoll, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Read)
+	defer cancel()
 
 	var currentVoter Voter
-	pattern := redisKeyFromId(int(voterId))
-	err := lst.getItemFromRedis(pattern, &currentVoter)
+	pattern := lst.key(redisKeyFromId(int(voterId)))
+	err := lst.getItemFromRedis(ctx, pattern, &currentVoter)
 	if err != nil {
-		return &voterPoll{}, err
+		return &VoterPoll{}, err
 	}
 
 	for j := 0; j < len(currentVoter.VoteHistory); j++ {
@@ -348,79 +1025,320 @@ func (lst *VoterList) GetVoterPollData(voterId uint, pollId uint) (*voterPoll, e
 		}
 	}
 
-	return nil, errors.New("item does not exist")
+	return nil, ErrPollNotFound
+
+}
 
+// AddVoterPollData records a vote for the voter, who has to exist
+// already, a vote is not a way to register.  The poll must exist and be
+// open, and repeat votes follow its VotePolicy.  The read-modify-write
+// runs inside a WATCH/MULTI transaction so two concurrent votes can
+// never overwrite each other.  The poll is WATCHed and read in the same
+// transaction, a vote cannot get in after the poll was closed.
+func (lst *VoterList) AddVoterPollData(ctx context.Context, voterId uint, pollId uint) error {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	return lst.modifyVoter(ctx, voterId, func(voter *Voter, found bool) error {
+		poll, err := lst.GetPollResource(ctx, pollId)
+		if err != nil {
+			return err
+		}
+		if err := poll.AcceptingVotes(now); err != nil {
+			return err
+		}
+		if !found {
+			return ErrVoterNotFound
+		}
+		return voter.recordVote(pollId, poll.votePolicy(&lst.votingRules), now)
+	}, lst.key(redisPollKeyFromId(pollId)))
 }
 
-func (lst *VoterList) AddVoterPollData(voterId uint, pollId uint) error {
+// DeletePoll removes the poll from the voters history, atomically in the
+// same way as AddVoterPollData
+func (lst *VoterList) DeletePoll(ctx context.Context, voterId uint, pollId uint) error {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Write)
+	defer cancel()
 
-	var currentVoter Voter
-	pattern := redisKeyFromId(int(voterId))
-	err := lst.getItemFromRedis(pattern, &currentVoter)
-	if err != nil {
-		newVoter := Voter{
-			VoterId: voterId,
+	return lst.modifyVoter(ctx, voterId, func(voter *Voter, found bool) error {
+		if !found {
+			return ErrVoterNotFound
 		}
-		// lst.Voters[voterId] = newVoter
+		if !voter.removePoll(pollId) {
+			return ErrPollNotFound
+		}
+		return nil
+	})
+}
 
-		newPoll := voterPoll{
-			PollID:   pollId,
-			VoteDate: time.Now(),
+//------------------------------------------------------------
+// POLL DEFINITIONS
+//------------------------------------------------------------
+
+func redisPollKeyFromId(id uint) string {
+	return fmt.Sprintf("%s%d", RedisPollKeyPrefix, id)
+}
+
+func (lst *VoterList) AddPollResource(ctx context.Context, poll Poll) error {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Write)
+	defer cancel()
+
+	poll = poll.withDefaults()
+	if err := poll.Validate(); err != nil {
+		return err
+	}
+
+	//NX makes the existence check and the write a single atomic step
+	_, err := lst.json(ctx).JSONSet(lst.key(redisPollKeyFromId(poll.PollID)), ".", poll, rjs.SetOptionNX)
+	if err != nil {
+		if isRedisNilError(err) {
+			return ErrPollExists
 		}
-		newVoter.VoteHistory = append(newVoter.VoteHistory, newPoll)
+		return storageError(err)
+	}
 
-		//Add item to database with JSON Set
-		redisKey := redisKeyFromId(int(voterId))
-		if _, err := lst.jsonHelper.JSONSet(redisKey, ".", newVoter); err != nil {
+	return nil
+}
+
+func (lst *VoterList) UpdatePollResource(ctx context.Context, poll Poll) error {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Write)
+	defer cancel()
+
+	key := lst.key(redisPollKeyFromId(poll.PollID))
+	return modifyJSON(ctx, lst, key, Poll{}, func(existing *Poll, found bool) error {
+		if !found {
+			return ErrPollNotFound
+		}
+		if err := checkPollUpdate(*existing, poll); err != nil {
 			return err
 		}
-		// lst.Voters[voterId] = newVoter
+		*existing = poll
+		return nil
+	}, nil)
+}
+
+func (lst *VoterList) DeletePollResource(ctx context.Context, id uint) error {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Write)
+	defer cancel()
+
+	numDeleted, err := lst.cacheClient.Del(ctx, lst.key(redisPollKeyFromId(id))).Result()
+	if err != nil {
+		return storageError(err)
+	}
+	if numDeleted == 0 {
+		return ErrPollNotFound
+	}
+
+	return nil
+}
+
+func (lst *VoterList) GetPollResource(ctx context.Context, id uint) (Poll, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Read)
+	defer cancel()
+
+	var poll Poll
+	if err := getJSON(ctx, lst, lst.key(redisPollKeyFromId(id)), &poll, ErrPollNotFound); err != nil {
+		return Poll{}, err
+	}
+
+	return poll, nil
+}
 
+func (lst *VoterList) GetAllPolls(ctx context.Context) ([]Poll, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Scan)
+	defer cancel()
+
+	var pollList []Poll
+	seen := make(map[uint]bool)
+	err := scanJSON(ctx, lst, lst.key(RedisPollKeyPrefix), func(poll Poll) error {
+		if !seen[poll.PollID] {
+			seen[poll.PollID] = true
+			pollList = append(pollList, poll)
+		}
 		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	newPoll := voterPoll{
-		PollID:   pollId,
-		VoteDate: time.Now(),
+	return pollList, nil
+}
+
+//------------------------------------------------------------
+// POLL INDEX
+//------------------------------------------------------------
+
+// The index is a sorted set per poll holding the ids of the voters that
+// voted in it.  The score is the voter id, which gives us cheap id
+// ordered paging with ZRANGEBYSCORE.
+
+func redisPollIndexKey(pollId uint) string {
+	return fmt.Sprintf("%s%d", RedisPollIndexPrefix, pollId)
+}
+
+// queueIndexChanges adds the ZADD/ZREM commands that move the voter
+// between polls to a MULTI, old or updated are nil for a voter that is
+// being created or deleted
+func (lst *VoterList) queueIndexChanges(ctx context.Context, pipe redis.Pipeliner, old, updated *Voter) {
+	var id uint
+	if old != nil {
+		id = old.VoterId
+	} else if updated != nil {
+		id = updated.VoterId
 	}
-	currentVoter.VoteHistory = append(currentVoter.VoteHistory, newPoll)
+	member := strconv.FormatUint(uint64(id), 10)
 
-	// lst.Voters[voterId] = currentVoter
-	//Add item to database with JSON Set
-	redisKey := redisKeyFromId(int(voterId))
-	if _, err := lst.jsonHelper.JSONSet(redisKey, ".", currentVoter); err != nil {
-		return err
+	added, removed := indexChanges(old, updated)
+	for _, pollId := range added {
+		pipe.ZAdd(ctx, lst.key(redisPollIndexKey(pollId)), &redis.Z{Score: float64(id), Member: member})
+	}
+	for _, pollId := range removed {
+		pipe.ZRem(ctx, lst.key(redisPollIndexKey(pollId)), member)
+	}
+}
+
+func (lst *VoterList) GetPollVoters(ctx context.Context, pollId uint, limit int, cursor string) (VoterPage, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Read)
+	defer cancel()
+
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
 	}
 
-	return nil
+	//The cursor is the last voter id of the previous page, "(" makes
+	//the range exclusive
+	from := "-inf"
+	if cursor != "" {
+		if _, err := strconv.ParseUint(cursor, 10, 64); err != nil {
+			return VoterPage{}, ErrInvalidQuery
+		}
+		from = "(" + cursor
+	}
+
+	key := lst.key(redisPollIndexKey(pollId))
+	total, err := lst.cacheClient.ZCard(ctx, key).Result()
+	if err != nil {
+		return VoterPage{}, storageError(err)
+	}
+
+	//Ask for one extra to know whether there is another page
+	members, err := lst.cacheClient.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min:   from,
+		Max:   "+inf",
+		Count: int64(limit + 1),
+	}).Result()
+	if err != nil {
+		return VoterPage{}, storageError(err)
+	}
+
+	page := VoterPage{Voters: make([]Voter, 0, limit), Total: int(total)}
+	if len(members) > limit {
+		members = members[:limit]
+		page.NextCursor = members[limit-1]
+	}
+	if len(members) == 0 {
+		return page, nil
+	}
+
+	keys := make([]string, len(members))
+	for i, m := range members {
+		keys[i] = lst.key(RedisKeyPrefix + m)
+	}
+	res, err := lst.json(ctx).JSONMGet(".", keys...)
+	if err != nil {
+		return VoterPage{}, storageError(err)
+	}
+	for _, raw := range res.([]interface{}) {
+		//a voter deleted outside of the API leaves a stale entry,
+		//skip it, RebuildPollIndex cleans those up
+		if raw == nil {
+			continue
+		}
+		var voter Voter
+		if err := json.Unmarshal(raw.([]byte), &voter); err != nil {
+			return VoterPage{}, err
+		}
+		page.Voters = append(page.Voters, voter)
+	}
+
+	return page, nil
 }
 
-func (lst *VoterList) DeletePoll(voterId uint, pollId uint) error {
+func (lst *VoterList) CountPollVoters(ctx context.Context, pollId uint) (int, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Read)
+	defer cancel()
 
-	var currentVoter Voter
-	pattern := redisKeyFromId(int(voterId))
-	err := lst.getItemFromRedis(pattern, &currentVoter)
+	n, err := lst.cacheClient.ZCard(ctx, lst.key(redisPollIndexKey(pollId))).Result()
 	if err != nil {
-		return err
+		return 0, storageError(err)
 	}
+	return int(n), nil
+}
 
-	index := 0
-	for j := 0; j < len(currentVoter.VoteHistory); j++ {
-		currentPoll := currentVoter.VoteHistory[j]
-		if currentPoll.PollID == pollId {
-			index = j
+// SchemaVersion reads the version from RedisSchemaKey, a redis that
+// has never been migrated is at version 0
+func (lst *VoterList) SchemaVersion(ctx context.Context) (int, error) {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Read)
+	defer cancel()
+
+	version, err := lst.cacheClient.Get(ctx, lst.key(RedisSchemaKey)).Int()
+	if err != nil {
+		if isRedisNilError(err) {
+			return 0, nil
 		}
+		return 0, storageError(err)
 	}
+	return version, nil
+}
 
-	currentVoter.VoteHistory = append(currentVoter.VoteHistory[:index], currentVoter.VoteHistory[index+1:]...)
+func (lst *VoterList) SetSchemaVersion(ctx context.Context, version int) error {
+	ctx, cancel := withTimeout(ctx, lst.timeouts.Write)
+	defer cancel()
 
-	// lst.Voters[voterId] = currentVoter
-	//Add item to database with JSON Set
-	// TODO: How to override voter here?
-	redisKey := redisKeyFromId(int(voterId))
-	if _, err := lst.jsonHelper.JSONSet(redisKey, ".", currentVoter); err != nil {
+	return storageError(lst.cacheClient.Set(ctx, lst.key(RedisSchemaKey), version, 0).Err())
+}
+
+// RebuildPollIndex drops every poll index and statistics key and
+// rebuilds them from the voter histories.  Votes recorded while this
+// runs may be missed, so run it when the API is quiet.  It is not bound
+// by the Scan timeout, a large voter roll can take a while, cancel ctx
+// to stop it.  The ids of the voters done so far are kept so a voter
+// SCAN hands out twice is not counted twice in the statistics.
+func (lst *VoterList) RebuildPollIndex(ctx context.Context) error {
+	for _, prefix := range []string{RedisPollIndexPrefix, RedisStatsPrefix} {
+		err := lst.scanKeys(ctx, lst.key(prefix), func(ks []string) error {
+			return storageError(lst.cacheClient.Del(ctx, ks...).Err())
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	pipe := lst.cacheClient.Pipeline()
+	done := make(map[uint]bool)
+	err := lst.scanVoters(ctx, func(voter Voter) error {
+		if done[voter.VoterId] {
+			return nil
+		}
+		done[voter.VoterId] = true
+		lst.queueVoterChanges(ctx, pipe, nil, &voter)
+		if pipe.Len() >= RedisScanCount {
+			if _, err := pipe.Exec(ctx); err != nil {
+				return storageError(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		return err
 	}
 
+	if _, err := pipe.Exec(ctx); err != nil {
+		return storageError(err)
+	}
 	return nil
 }
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	concurrentVotes    = 200
+	concurrentVoterID  = 1000
+	concurrentVotePoll = 1000
+)
+
+// TestConcurrentVotes fires votes at one voter from many goroutines at
+// once and checks every one of them made it into the history, with one
+// revision per vote
+func TestConcurrentVotes(t *testing.T) {
+	forEachStore(t, testConcurrentVotes)
+}
+
+// forEachStore runs test against an empty store of every backend.  It
+// runs against redis as well when REDIS_URL is set, in keys of its own
+// so nothing already in there is touched.
+func forEachStore(t *testing.T, test func(t *testing.T, store VoterStore)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryVoterList())
+	})
+
+	t.Run("file", func(t *testing.T) {
+		lst, err := NewWithFileInstance(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, lst)
+	})
+
+	t.Run("redis", func(t *testing.T) {
+		addr := os.Getenv("REDIS_URL")
+		if addr == "" {
+			t.Skip("REDIS_URL is not set")
+		}
+		lst, err := NewVoterList(RedisOptions{
+			Addr:      addr,
+			KeyPrefix: fmt.Sprintf("test%d:", time.Now().UnixNano()),
+			Timeouts:  DefaultTimeouts,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			ctx := context.Background()
+			lst.DeleteAll(ctx)
+			polls, _ := lst.GetAllPolls(ctx)
+			for _, poll := range polls {
+				lst.DeletePollResource(ctx, poll.PollID)
+			}
+			lst.Close()
+		})
+		test(t, lst)
+	})
+}
+
+func testConcurrentVotes(t *testing.T, store VoterStore) {
+	ctx := context.Background()
+
+	poll := Poll{PollID: concurrentVotePoll, Title: "Stress test", Status: PollOpen, VotePolicy: PolicyMultiple}
+	if err := store.AddPollResource(ctx, poll); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddVoter(ctx, *NewVoter(concurrentVoterID, "Stress", "Test")); err != nil {
+		t.Fatal(err)
+	}
+
+	//All the goroutines are released at once so the votes really do
+	//overlap instead of trickling in as the goroutines are created
+	start := make(chan struct{})
+	errs := make(chan error, concurrentVotes)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentVotes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs <- store.AddVoterPollData(ctx, concurrentVoterID, concurrentVotePoll)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("vote failed: %v", err)
+		}
+	}
+
+	voter, err := store.GetSingleVoterResource(ctx, concurrentVoterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(voter.VoteHistory); got != concurrentVotes {
+		t.Errorf("expected %d votes in the history, found %d", concurrentVotes, got)
+	}
+	//Revision 1 is the voter as it was added, every vote adds one
+	if want := uint64(concurrentVotes + 1); voter.Revision != want {
+		t.Errorf("expected revision %d, found %d", want, voter.Revision)
+	}
+}
@@ -1,4 +1,10 @@
 #!/bin/bash
-curl -d '{ "id": 1, "firstname": "John", "lastname": "Doe", "votehistory": [{"pollid": 59231, "votedate": "2021-08-15T14:30:45.00Z"}] }' -H "Content-Type: application/json" -X POST http://localhost:1080/voters/1
-curl -d '{ "id": 2, "firstname": "Jane", "lastname": "Schmoe", "votehistory": [{"pollid": 12342, "votedate": "2021-08-16T14:30:45.00Z"}] }' -H "Content-Type: application/json" -X POST http://localhost:1080/voters/2
-curl -d '{ "id": 3, "firstname": "Bob", "lastname": "Ross", "votehistory": [{"pollid": 54323, "votedate": "2021-08-17T14:30:45.00Z"}] }' -H "Content-Type: application/json" -X POST http://localhost:1080/voters/3
\ No newline at end of file
+# Loads the sample voters with a single bulk request, pass a CSV or NDJSON
+# file to load that instead.  Add DRYRUN=true to only see the report.
+file=${1:-./data/voters.sample.csv}
+case "$file" in
+  *.csv) type=text/csv ;;
+  *) type=application/x-ndjson ;;
+esac
+curl -s -w "\nHTTP Status: %{http_code}\n" --data-binary @"$file" -H "Content-Type: $type" \
+  -X POST "http://localhost:1080/voters:batch?dryrun=${DRYRUN:-false}"
@@ -0,0 +1,64 @@
+// Package logging sets up the structured logger used across the service
+// and carries it through a context.Context, so everything logged while
+// handling a request, down to the redis commands it caused, is tagged
+// with the same request id.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+
+	DefaultLevel  = "info"
+	DefaultFormat = FormatJSON
+
+	//RequestIDKey is the attribute every request scoped line carries
+	RequestIDKey = "request_id"
+)
+
+// New builds a logger writing to w.  level is one of debug, info, warn
+// or error and format is json or text, empty strings pick the defaults.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	if level == "" {
+		level = DefaultLevel
+	}
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "", FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx carrying logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger
+// if there is none (for example during startup or in the CLI commands)
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
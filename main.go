@@ -3,19 +3,40 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"strings"
 
-	"drexel.edu/todo/api"
-	"github.com/gin-contrib/cors"
-	"github.com/gin-gonic/gin"
+	"drexel.edu/todo/config"
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/logging"
 )
 
-// Global variables to hold the command line flags to drive the todo CLI
-// application
-var (
-	hostFlag string
-	portFlag uint
-)
+// command is one of the subcommands of the voter binary.  Every command
+// but serve works on the configured store directly, the API does not
+// have to be running.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "Run the voter API (the default)", runServe},
+	{"import", "Load voters from a JSON, NDJSON or CSV file", runImport},
+	{"export", "Write every voter as JSON, NDJSON or CSV", runExport},
+	{"seed", "Fill the store with generated polls and voters", runSeed},
+	{"migrate", "Bring the stored data up to the current schema version", runMigrate},
+	{"reindex", "Rebuild the poll to voter index and the statistics", runReindex},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -help for the flags of a command.\n", os.Args[0])
+}
 
 // processCmdLineFlags parses the command line flags for our CLI
 //
@@ -34,68 +55,65 @@ var (
 //						   use it.  See github.com/spf13/cobra for information
 //						   on how to use it.
 //
-//	 YOUR ANSWER: <GOES HERE>
-func processCmdLineFlags() {
+//	 YOUR ANSWER: Every subcommand has its own flag.FlagSet, it defines
+//	 the flags only it understands on it and then hands it to this
+//	 function.  The settings themselves are flags defined by config.Load,
+//	 which also reads the config file and the environment, see the config
+//	 package for the order they are applied in, so every command can
+//	 point at any store.  Whatever is left after the flags are the
+//	 command's positional arguments, fs.Args().
+func processCmdLineFlags(fs *flag.FlagSet, args []string) (config.Config, error) {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s %s:\n", os.Args[0], fs.Name())
+		fs.PrintDefaults()
+	}
+	cfg, err := config.Load(fs, args)
+	if err != nil {
+		return config.Config{}, err
+	}
 
-	//Note some networking lingo, some frameworks start the server on localhost
-	//this is a local-only interface and is fine for testing but its not accessible
-	//from other machines.  To make the server accessible from other machines, we
-	//need to listen on an interface, that could be an IP address, but modern
-	//cloud servers may have multiple network interfaces for scale.  With TCP/IP
-	//the address 0.0.0.0 instructs the network stack to listen on all interfaces
-	//We set this up as a flag so that we can overwrite it on the command line if
-	//needed
-	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
-	flag.UintVar(&portFlag, "p", 1080, "Default Port")
+	//Validate has already checked the level and format
+	logger, _ := logging.New(os.Stderr, cfg.Log.Level, cfg.Log.Format)
+	slog.SetDefault(logger)
 
-	flag.Parse()
+	return cfg, nil
 }
 
-// main is the entry point for our todo API application.  It processes
-// the command line flags and then uses the db package to perform the
-// requested operation
-func main() {
-	processCmdLineFlags()
-	r := gin.Default()
-	r.Use(cors.Default())
-
-	apiHandler, err := api.New()
+// openStore opens the configured storage backend with the configured
+// voting rules
+func openStore(cfg config.Config) (db.VoterStore, error) {
+	store, err := db.NewVoterStore(cfg.StoreOptions())
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, fmt.Errorf("cannot open the %s voter store: %w", cfg.Store.Backend, err)
 	}
+	rules, _ := cfg.VotingRules()
+	store.SetVotingRules(rules)
+	return store, nil
+}
 
-	r.GET("/voters", apiHandler.GetAllVoterResources)
-
-	r.GET("/voters/:id", apiHandler.GetSingleVoterResource)
-	// Create a voters resource with id = :id, initialize the polls slice to an
-	// empty slice
-	r.POST("/voters/:id", apiHandler.AddVoter)
-
-	r.GET("/voters/:id/polls", apiHandler.GetVoterHistory)
-
-	r.GET("/voters/:id/polls/:pollid", apiHandler.GetVoterPollData)
-	// Look up the voter with id = :id, then add the poll with pollid = :pollid to
-	// the internal poll slice
-	// POST /voters/22/polls/3
-	// Does voter 22 exist, if not return 404 error; if voter 22 exists, add
-	// pollid 3 to the internal poll slice
-	// ***** Does voter 22 exist, if not, create voter 22 (WHICH ASSUMES ALL THE
-	// VOTER INFO IS IN THE PAYLOAD), then voter 22, then
-	// add pollid 3 to the NEW voter 22 resource. If not, follow above
-	r.POST("/voters/:id/polls/:pollid", apiHandler.AddVoterPollData)
-
-	r.GET("/voters/health", apiHandler.HealthCheck)
-
-	// Extra Credit
-	r.DELETE("/voters", apiHandler.DeleteAllVoters)
-
-	r.DELETE("/voters/:id", apiHandler.DeleteVoter)
-
-	r.DELETE("/voters/:id/polls/:pollid", apiHandler.DeletePoll)
+// main is the entry point for our voter application.  The first argument
+// picks the command, without one (or when it is a flag, so the old
+// `voter -p 1080` keeps working) the API is served.
+func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-	r.PUT("/voters", apiHandler.UpdateVoter)
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if err := c.run(args); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
-	r.Run(serverPath)
+	if name != "help" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	}
+	usage()
+	os.Exit(2)
 }
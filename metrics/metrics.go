@@ -0,0 +1,98 @@
+// Package metrics exposes the service metrics in the Prometheus text
+// format.  HTTP requests are measured by a gin middleware and redis
+// commands by a go-redis hook, so nothing here has to change when a
+// route is added.  Storage calls are timed by Store, which has one
+// wrapper per db.VoterStore method: a new storage operation needs a
+// wrapper of its own or it runs untimed through the embedded store,
+// TestStoreWrapsEveryMethod fails until it has one.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "voter_api"
+
+var (
+	registry = prometheus.NewRegistry()
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	storeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_operation_duration_seconds",
+		Help:      "Latency of db.VoterStore operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	storeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "store_operation_errors_total",
+		Help:      "db.VoterStore operations that returned an error, kind is storage for a failed backend, timeout or canceled when the deadline passed or the client went away, and request for not found, conflicts and the like.",
+	}, []string{"operation", "kind"})
+
+	redisDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Latency of redis commands (JSON.GET, JSON.SET, DEL, SCAN, ...).",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command"})
+
+	redisErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redis_command_errors_total",
+		Help:      "Redis commands that failed, a missing key is not counted.",
+	}, []string{"command"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration,
+		storeDuration, storeErrors,
+		redisDuration, redisErrors,
+	)
+}
+
+// Middleware records the count and latency of every request.  The route
+// label is the route pattern (/voters/:id), not the actual path, so the
+// number of series stays bounded.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		httpRequests.WithLabelValues(route, c.Request.Method, status).Inc()
+		httpDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves GET /metrics
+func Handler() gin.HandlerFunc {
+	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	return gin.WrapH(h)
+}
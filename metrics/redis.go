@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type startKey struct{}
+
+// RedisHook is a go-redis hook that times every command sent to redis,
+// including the ones sent as part of a pipeline or MULTI
+type RedisHook struct{}
+
+var _ redis.Hook = RedisHook{}
+
+func (RedisHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func (RedisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(startKey{}).(time.Time); ok {
+		observeRedis(cmd, time.Since(start))
+	}
+	return nil
+}
+
+func (RedisHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+// AfterProcessPipeline splits the round trip evenly between the commands
+// in the pipeline, there is no way to time them individually
+func (RedisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	start, ok := ctx.Value(startKey{}).(time.Time)
+	if !ok || len(cmds) == 0 {
+		return nil
+	}
+	each := time.Since(start) / time.Duration(len(cmds))
+	for _, cmd := range cmds {
+		observeRedis(cmd, each)
+	}
+	return nil
+}
+
+func observeRedis(cmd redis.Cmder, d time.Duration) {
+	name := strings.ToUpper(cmd.Name())
+	redisDuration.WithLabelValues(name).Observe(d.Seconds())
+	if err := cmd.Err(); err != nil && !errors.Is(err, redis.Nil) {
+		redisErrors.WithLabelValues(name).Inc()
+	}
+}
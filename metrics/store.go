@@ -0,0 +1,225 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Store wraps a db.VoterStore and times every call.  It also exports
+// the total number of voters and votes as gauges, read from the store
+// when /metrics is scraped.  Every method of db.VoterStore needs its
+// own wrapper below, one left out is still served by the embedded
+// store but never shows up in the metrics.
+type Store struct {
+	db.VoterStore
+}
+
+// InstrumentStore returns store wrapped in a Store, and registers the
+// voter and vote gauges against it
+func InstrumentStore(store db.VoterStore) *Store {
+	s := &Store{VoterStore: store}
+
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "voters",
+		Help:      "Number of registered voters.",
+	}, func() float64 {
+		voters, _, _ := store.VoterCounts(context.Background())
+		return float64(voters)
+	}))
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "votes",
+		Help:      "Number of votes recorded across all voters.",
+	}, func() float64 {
+		_, votes, _ := store.VoterCounts(context.Background())
+		return float64(votes)
+	}))
+
+	return s
+}
+
+// observe is deferred at the top of every wrapped call
+func observe(op string, start time.Time, err error) {
+	storeDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	switch {
+	case err == nil:
+	case errors.Is(err, db.ErrTimeout):
+		storeErrors.WithLabelValues(op, "timeout").Inc()
+	case errors.Is(err, db.ErrCanceled):
+		storeErrors.WithLabelValues(op, "canceled").Inc()
+	case errors.Is(err, db.ErrStorageUnavailable):
+		storeErrors.WithLabelValues(op, "storage").Inc()
+	default:
+		storeErrors.WithLabelValues(op, "request").Inc()
+	}
+}
+
+func (s *Store) AddVoter(ctx context.Context, voter db.Voter) (_ db.Voter, err error) {
+	defer func(start time.Time) { observe("AddVoter", start, err) }(time.Now())
+	return s.VoterStore.AddVoter(ctx, voter)
+}
+
+func (s *Store) AddVoters(ctx context.Context, voters []db.Voter, dryRun bool) (_ []error, err error) {
+	defer func(start time.Time) { observe("AddVoters", start, err) }(time.Now())
+	return s.VoterStore.AddVoters(ctx, voters, dryRun)
+}
+
+func (s *Store) CreateVoter(ctx context.Context, voter db.Voter) (_ db.Voter, err error) {
+	defer func(start time.Time) { observe("CreateVoter", start, err) }(time.Now())
+	return s.VoterStore.CreateVoter(ctx, voter)
+}
+
+func (s *Store) UpdateVoter(ctx context.Context, voter db.Voter) (err error) {
+	defer func(start time.Time) { observe("UpdateVoter", start, err) }(time.Now())
+	return s.VoterStore.UpdateVoter(ctx, voter)
+}
+
+func (s *Store) PatchVoter(ctx context.Context, id uint, fn func(voter *db.Voter) error) (_ db.Voter, err error) {
+	defer func(start time.Time) { observe("PatchVoter", start, err) }(time.Now())
+	return s.VoterStore.PatchVoter(ctx, id, fn)
+}
+
+func (s *Store) DeleteVoter(ctx context.Context, id uint, revision uint64) (err error) {
+	defer func(start time.Time) { observe("DeleteVoter", start, err) }(time.Now())
+	return s.VoterStore.DeleteVoter(ctx, id, revision)
+}
+
+func (s *Store) DeleteAll(ctx context.Context) (err error) {
+	defer func(start time.Time) { observe("DeleteAll", start, err) }(time.Now())
+	return s.VoterStore.DeleteAll(ctx)
+}
+
+func (s *Store) GetSingleVoterResource(ctx context.Context, id uint) (_ db.Voter, err error) {
+	defer func(start time.Time) { observe("GetSingleVoterResource", start, err) }(time.Now())
+	return s.VoterStore.GetSingleVoterResource(ctx, id)
+}
+
+func (s *Store) GetAllVoters(ctx context.Context) (_ []db.Voter, err error) {
+	defer func(start time.Time) { observe("GetAllVoters", start, err) }(time.Now())
+	return s.VoterStore.GetAllVoters(ctx)
+}
+
+func (s *Store) ListVoters(ctx context.Context, q db.VoterQuery) (_ db.VoterPage, err error) {
+	defer func(start time.Time) { observe("ListVoters", start, err) }(time.Now())
+	return s.VoterStore.ListVoters(ctx, q)
+}
+
+func (s *Store) ScanVoters(ctx context.Context, q db.VoterQuery, fn func(voter db.Voter) error) (err error) {
+	defer func(start time.Time) { observe("ScanVoters", start, err) }(time.Now())
+	return s.VoterStore.ScanVoters(ctx, q, fn)
+}
+
+func (s *Store) GetVoterHistory(ctx context.Context, id uint) (_ []db.VoterPoll, err error) {
+	defer func(start time.Time) { observe("GetVoterHistory", start, err) }(time.Now())
+	return s.VoterStore.GetVoterHistory(ctx, id)
+}
+
+func (s *Store) GetVoterPollData(ctx context.Context, voterId uint, pollId uint) (_ *db.VoterPoll, err error) {
+	defer func(start time.Time) { observe("GetVoterPollData", start, err) }(time.Now())
+	return s.VoterStore.GetVoterPollData(ctx, voterId, pollId)
+}
+
+func (s *Store) AddVoterPollData(ctx context.Context, voterId uint, pollId uint) (err error) {
+	defer func(start time.Time) { observe("AddVoterPollData", start, err) }(time.Now())
+	return s.VoterStore.AddVoterPollData(ctx, voterId, pollId)
+}
+
+func (s *Store) DeletePoll(ctx context.Context, voterId uint, pollId uint) (err error) {
+	defer func(start time.Time) { observe("DeletePoll", start, err) }(time.Now())
+	return s.VoterStore.DeletePoll(ctx, voterId, pollId)
+}
+
+func (s *Store) Ping(ctx context.Context) (err error) {
+	defer func(start time.Time) { observe("Ping", start, err) }(time.Now())
+	return s.VoterStore.Ping(ctx)
+}
+
+func (s *Store) GetPollVoters(ctx context.Context, pollId uint, limit int, cursor string) (_ db.VoterPage, err error) {
+	defer func(start time.Time) { observe("GetPollVoters", start, err) }(time.Now())
+	return s.VoterStore.GetPollVoters(ctx, pollId, limit, cursor)
+}
+
+func (s *Store) CountPollVoters(ctx context.Context, pollId uint) (_ int, err error) {
+	defer func(start time.Time) { observe("CountPollVoters", start, err) }(time.Now())
+	return s.VoterStore.CountPollVoters(ctx, pollId)
+}
+
+func (s *Store) RebuildPollIndex(ctx context.Context) (err error) {
+	defer func(start time.Time) { observe("RebuildPollIndex", start, err) }(time.Now())
+	return s.VoterStore.RebuildPollIndex(ctx)
+}
+
+func (s *Store) SchemaVersion(ctx context.Context) (_ int, err error) {
+	defer func(start time.Time) { observe("SchemaVersion", start, err) }(time.Now())
+	return s.VoterStore.SchemaVersion(ctx)
+}
+
+func (s *Store) SetSchemaVersion(ctx context.Context, version int) (err error) {
+	defer func(start time.Time) { observe("SetSchemaVersion", start, err) }(time.Now())
+	return s.VoterStore.SetSchemaVersion(ctx, version)
+}
+
+func (s *Store) PollStatistics(ctx context.Context) (_ []db.PollStats, err error) {
+	defer func(start time.Time) { observe("PollStatistics", start, err) }(time.Now())
+	return s.VoterStore.PollStatistics(ctx)
+}
+
+func (s *Store) PollReport(ctx context.Context, pollId uint, bucket string) (_ db.PollReport, err error) {
+	defer func(start time.Time) { observe("PollReport", start, err) }(time.Now())
+	return s.VoterStore.PollReport(ctx, pollId, bucket)
+}
+
+func (s *Store) TopVoters(ctx context.Context, n int) (_ []db.VoterActivity, err error) {
+	defer func(start time.Time) { observe("TopVoters", start, err) }(time.Now())
+	return s.VoterStore.TopVoters(ctx, n)
+}
+
+func (s *Store) VoterCounts(ctx context.Context) (voters int, votes int, err error) {
+	defer func(start time.Time) { observe("VoterCounts", start, err) }(time.Now())
+	return s.VoterStore.VoterCounts(ctx)
+}
+
+func (s *Store) AddPollResource(ctx context.Context, poll db.Poll) (err error) {
+	defer func(start time.Time) { observe("AddPollResource", start, err) }(time.Now())
+	return s.VoterStore.AddPollResource(ctx, poll)
+}
+
+func (s *Store) UpdatePollResource(ctx context.Context, poll db.Poll) (err error) {
+	defer func(start time.Time) { observe("UpdatePollResource", start, err) }(time.Now())
+	return s.VoterStore.UpdatePollResource(ctx, poll)
+}
+
+func (s *Store) DeletePollResource(ctx context.Context, id uint) (err error) {
+	defer func(start time.Time) { observe("DeletePollResource", start, err) }(time.Now())
+	return s.VoterStore.DeletePollResource(ctx, id)
+}
+
+func (s *Store) GetPollResource(ctx context.Context, id uint) (_ db.Poll, err error) {
+	defer func(start time.Time) { observe("GetPollResource", start, err) }(time.Now())
+	return s.VoterStore.GetPollResource(ctx, id)
+}
+
+func (s *Store) GetAllPolls(ctx context.Context) (_ []db.Poll, err error) {
+	defer func(start time.Time) { observe("GetAllPolls", start, err) }(time.Now())
+	return s.VoterStore.GetAllPolls(ctx)
+}
+
+func (s *Store) ReserveIdempotencyKey(ctx context.Context, key string, fingerprint string, ttl time.Duration) (_ *db.IdempotencyRecord, err error) {
+	defer func(start time.Time) { observe("ReserveIdempotencyKey", start, err) }(time.Now())
+	return s.VoterStore.ReserveIdempotencyKey(ctx, key, fingerprint, ttl)
+}
+
+func (s *Store) SaveIdempotencyKey(ctx context.Context, key string, record db.IdempotencyRecord, ttl time.Duration) (err error) {
+	defer func(start time.Time) { observe("SaveIdempotencyKey", start, err) }(time.Now())
+	return s.VoterStore.SaveIdempotencyKey(ctx, key, record, ttl)
+}
+
+func (s *Store) ReleaseIdempotencyKey(ctx context.Context, key string) (err error) {
+	defer func(start time.Time) { observe("ReleaseIdempotencyKey", start, err) }(time.Now())
+	return s.VoterStore.ReleaseIdempotencyKey(ctx, key)
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+
+	"drexel.edu/todo/db"
+)
+
+// notTimed are the db.VoterStore methods left to the embedded store on
+// purpose, they set the store up or shut it down rather than serve a
+// request
+var notTimed = map[string]bool{
+	"SetVotingRules": true,
+	"Close":          true,
+}
+
+// TestStoreWrapsEveryMethod checks Store declares a wrapper for every
+// method of db.VoterStore, bar the few in notTimed.  The embedded store
+// would serve a missing one just as well, so the compiler never notices
+// when one is left out.
+func TestStoreWrapsEveryMethod(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "store.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapped := map[string]bool{}
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil || len(fn.Recv.List) != 1 {
+			continue
+		}
+		if star, ok := fn.Recv.List[0].Type.(*ast.StarExpr); ok {
+			if ident, ok := star.X.(*ast.Ident); ok && ident.Name == "Store" {
+				wrapped[fn.Name.Name] = true
+			}
+		}
+	}
+
+	store := reflect.TypeOf((*db.VoterStore)(nil)).Elem()
+	for i := 0; i < store.NumMethod(); i++ {
+		if name := store.Method(i).Name; !wrapped[name] && !notTimed[name] {
+			t.Errorf("db.VoterStore.%s has no wrapper in Store, its calls are not timed", name)
+		}
+	}
+}
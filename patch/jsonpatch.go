@@ -0,0 +1,309 @@
+package patch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Operation is one step of a JSON Patch, Value is only set for the
+// operations that take one and From only for move and copy
+type Operation struct {
+	Op    string
+	Path  string
+	From  string
+	Value any
+
+	path []string
+	from []string
+}
+
+// JSONPatch is an RFC 6902 JSON Patch, a list of operations applied one
+// after the other.  If any of them fails the whole patch does.
+type JSONPatch []Operation
+
+func parseJSONPatch(body []byte) (JSONPatch, error) {
+	var raw []map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("%w: must be an array of operations: %w", ErrInvalidPatch, err)
+	}
+
+	ops := make(JSONPatch, 0, len(raw))
+	for i, members := range raw {
+		op, err := parseOperation(members)
+		if err != nil {
+			return nil, fmt.Errorf("%w: operation %d: %w", ErrInvalidPatch, i, err)
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func parseOperation(members map[string]json.RawMessage) (Operation, error) {
+	var op Operation
+	str := func(name string, dst *string) error {
+		raw, ok := members[name]
+		if !ok {
+			return fmt.Errorf("%s is missing", name)
+		}
+		if err := json.Unmarshal(raw, dst); err != nil {
+			return fmt.Errorf("%s must be a string", name)
+		}
+		return nil
+	}
+
+	if err := str("op", &op.Op); err != nil {
+		return op, err
+	}
+	if err := str("path", &op.Path); err != nil {
+		return op, err
+	}
+	var err error
+	if op.path, err = parsePointer(op.Path); err != nil {
+		return op, fmt.Errorf("path: %w", err)
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		raw, ok := members["value"]
+		if !ok {
+			return op, fmt.Errorf("value is missing")
+		}
+		if op.Value, err = decode(raw); err != nil {
+			return op, fmt.Errorf("value: %w", err)
+		}
+	case "move", "copy":
+		if err := str("from", &op.From); err != nil {
+			return op, err
+		}
+		if op.from, err = parsePointer(op.From); err != nil {
+			return op, fmt.Errorf("from: %w", err)
+		}
+		if op.Op == "move" && isProperPrefix(op.from, op.path) {
+			return op, fmt.Errorf("cannot move %q into itself", op.From)
+		}
+	case "remove":
+	default:
+		return op, fmt.Errorf("unknown op %q", op.Op)
+	}
+	return op, nil
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its reference
+// tokens, the empty pointer is the whole document
+func parsePointer(s string) ([]string, error) {
+	if s == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("%q does not start with /", s)
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+func isProperPrefix(prefix, path []string) bool {
+	if len(prefix) >= len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply runs the operations against doc in order and returns the result
+func (p JSONPatch) Apply(doc []byte) ([]byte, error) {
+	return applyTo(doc, func(v any) (any, error) {
+		for i, op := range p {
+			var err error
+			if v, err = op.apply(v); err != nil {
+				return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+			}
+		}
+		return v, nil
+	})
+}
+
+func (op Operation) apply(doc any) (any, error) {
+	switch op.Op {
+	case "add":
+		return add(doc, op.path, deepCopy(op.Value))
+	case "remove":
+		doc, _, err := remove(doc, op.path)
+		return doc, err
+	case "replace":
+		if _, err := get(doc, op.path); err != nil {
+			return nil, err
+		}
+		if len(op.path) == 0 {
+			return deepCopy(op.Value), nil
+		}
+		doc, _, err := remove(doc, op.path)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.path, deepCopy(op.Value))
+	case "move":
+		doc, value, err := remove(doc, op.from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.path, value)
+	case "copy":
+		value, err := get(doc, op.from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.path, deepCopy(value))
+	case "test":
+		value, err := get(doc, op.path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(value, op.Value) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, op.Op)
+	}
+}
+
+func notFound(path []string) error {
+	return fmt.Errorf("%w: /%s does not exist", ErrCannotApply, strings.Join(path, "/"))
+}
+
+// arrayIndex parses an array index token, end allows one past the last
+// element for inserts
+func arrayIndex(token string, n int, end bool) (int, error) {
+	if token == "-" && end {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("%w: %q is not an array index", ErrCannotApply, token)
+	}
+	if i > n || (i == n && !end) {
+		return 0, fmt.Errorf("%w: index %d is out of range", ErrCannotApply, i)
+	}
+	return i, nil
+}
+
+func get(doc any, path []string) (any, error) {
+	v := doc
+	for i, token := range path {
+		switch n := v.(type) {
+		case map[string]any:
+			child, ok := n[token]
+			if !ok {
+				return nil, notFound(path[:i+1])
+			}
+			v = child
+		case []any:
+			idx, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			v = n[idx]
+		default:
+			return nil, notFound(path[:i+1])
+		}
+	}
+	return v, nil
+}
+
+// update walks down to the parent of the last token of path and
+// replaces it with what fn makes of it.  Arrays change length, so every
+// container on the way down is put back into its own parent.
+func update(doc any, path []string, fn func(parent any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	switch n := doc.(type) {
+	case map[string]any:
+		child, ok := n[path[0]]
+		if !ok {
+			return nil, notFound(path[:1])
+		}
+		child, err := update(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = child
+		return n, nil
+	case []any:
+		idx, err := arrayIndex(path[0], len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		child, err := update(n[idx], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[idx] = child
+		return n, nil
+	default:
+		return nil, notFound(path[:1])
+	}
+}
+
+func add(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(doc, path, func(parent any, token string) (any, error) {
+		switch n := parent.(type) {
+		case map[string]any:
+			n[token] = value
+			return n, nil
+		case []any:
+			idx, err := arrayIndex(token, len(n), true)
+			if err != nil {
+				return nil, err
+			}
+			n = append(n, nil)
+			copy(n[idx+1:], n[idx:])
+			n[idx] = value
+			return n, nil
+		default:
+			return nil, notFound(path[:len(path)-1])
+		}
+	})
+}
+
+// remove takes the value at path out of doc and returns it as well
+func remove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("%w: the whole document cannot be removed", ErrCannotApply)
+	}
+	var removed any
+	doc, err := update(doc, path, func(parent any, token string) (any, error) {
+		switch n := parent.(type) {
+		case map[string]any:
+			value, ok := n[token]
+			if !ok {
+				return nil, notFound(path)
+			}
+			removed = value
+			delete(n, token)
+			return n, nil
+		case []any:
+			idx, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = n[idx]
+			return append(n[:idx], n[idx+1:]...), nil
+		default:
+			return nil, notFound(path[:len(path)-1])
+		}
+	})
+	return doc, removed, err
+}
@@ -0,0 +1,46 @@
+package patch
+
+import "fmt"
+
+// MergePatch is an RFC 7396 merge patch.  Members of an object in the
+// patch replace the same members of the document, a null removes the
+// member, and anything that is not an object replaces the value whole,
+// arrays included.
+type MergePatch struct {
+	patch any
+}
+
+func parseMergePatch(body []byte) (*MergePatch, error) {
+	v, err := decode(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+	}
+	return &MergePatch{patch: v}, nil
+}
+
+// Apply merges the patch into doc and returns the result
+func (p *MergePatch) Apply(doc []byte) ([]byte, error) {
+	return applyTo(doc, func(v any) (any, error) {
+		return merge(v, p.patch), nil
+	})
+}
+
+// merge is the MergePatch function of RFC 7396 section 2
+func merge(target, patch any) any {
+	members, ok := patch.(map[string]any)
+	if !ok {
+		return deepCopy(patch)
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+	for name, value := range members {
+		if value == nil {
+			delete(t, name)
+		} else {
+			t[name] = merge(t[name], value)
+		}
+	}
+	return t
+}
@@ -0,0 +1,154 @@
+// Package patch applies JSON Merge Patch (RFC 7396) and JSON Patch
+// (RFC 6902) documents to JSON documents.  It works on the decoded JSON
+// rather than on Go structs, so a patch can only touch what the JSON of
+// a resource shows and the caller decides whether the result is valid.
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+)
+
+// The media types of the two patch formats
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+// AcceptPatch is the value of the Accept-Patch header, the formats Parse
+// understands
+const AcceptPatch = MergePatchContentType + ", " + JSONPatchContentType
+
+var (
+	//ErrUnsupportedFormat is returned by Parse for a content type that
+	//is not one of the patch formats
+	ErrUnsupportedFormat = errors.New("unsupported patch format")
+	//ErrInvalidPatch means the patch document itself is malformed
+	ErrInvalidPatch = errors.New("invalid patch document")
+	//ErrCannotApply means the patch is well formed but does not fit
+	//the document, for example it removes a path that is not there
+	ErrCannotApply = errors.New("patch cannot be applied")
+	//ErrTestFailed is returned when a JSON Patch test operation does not
+	//match, the document was not what the client expected
+	ErrTestFailed = errors.New("patch test failed")
+)
+
+// Patch is a parsed patch document.  Apply does not change the patch,
+// the same patch can be applied again, which is what happens when a
+// write has to be retried.
+type Patch interface {
+	Apply(doc []byte) ([]byte, error)
+}
+
+// Parse reads a patch document in the format named by contentType,
+// parameters such as charset are ignored
+func Parse(contentType string, body []byte) (Patch, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, contentType)
+	}
+	switch mediaType {
+	case MergePatchContentType:
+		return parseMergePatch(body)
+	case JSONPatchContentType:
+		return parseJSONPatch(body)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, mediaType)
+	}
+}
+
+// decode reads any JSON value, numbers are kept as json.Number so ids
+// and other integers survive the round trip exactly
+func decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+// applyTo decodes doc, hands it to fn and encodes what fn returns
+func applyTo(doc []byte, fn func(v any) (any, error)) ([]byte, error) {
+	v, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	v, err = fn(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// deepCopy copies a decoded JSON value so that changing the copy leaves
+// the original alone
+func deepCopy(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[k] = deepCopy(e)
+		}
+		return m
+	case []any:
+		a := make([]any, len(v))
+		for i, e := range v {
+			a[i] = deepCopy(e)
+		}
+		return a
+	default:
+		return v
+	}
+}
+
+// equal compares decoded JSON values the way RFC 6902 section 4.6 asks
+// for, numbers are equal when their values are, 1 and 1.0 included
+func equal(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		bm, ok := b.(map[string]any)
+		if !ok || len(a) != len(bm) {
+			return false
+		}
+		for k, e := range a {
+			be, ok := bm[k]
+			if !ok || !equal(e, be) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		ba, ok := b.([]any)
+		if !ok || len(a) != len(ba) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], ba[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		bn, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if a == bn {
+			return true
+		}
+		af, aerr := strconv.ParseFloat(string(a), 64)
+		bf, berr := strconv.ParseFloat(string(bn), 64)
+		return aerr == nil && berr == nil && af == bf
+	default:
+		return a == b
+	}
+}
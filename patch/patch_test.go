@@ -0,0 +1,276 @@
+package patch
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type patchTest struct {
+	name  string
+	doc   string
+	patch string
+	want  string
+	err   error
+}
+
+// jsonPatchTests starts with every example of RFC 6902 appendix A, in
+// order, then goes through the corners the examples leave out
+var jsonPatchTests = []patchTest{
+	{name: "A.1 add an object member", doc: `{"foo":"bar"}`,
+		patch: `[{"op":"add","path":"/baz","value":"qux"}]`,
+		want:  `{"baz":"qux","foo":"bar"}`},
+	{name: "A.2 add an array element", doc: `{"foo":["bar","baz"]}`,
+		patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+		want:  `{"foo":["bar","qux","baz"]}`},
+	{name: "A.3 remove an object member", doc: `{"baz":"qux","foo":"bar"}`,
+		patch: `[{"op":"remove","path":"/baz"}]`,
+		want:  `{"foo":"bar"}`},
+	{name: "A.4 remove an array element", doc: `{"foo":["bar","qux","baz"]}`,
+		patch: `[{"op":"remove","path":"/foo/1"}]`,
+		want:  `{"foo":["bar","baz"]}`},
+	{name: "A.5 replace a value", doc: `{"baz":"qux","foo":"bar"}`,
+		patch: `[{"op":"replace","path":"/baz","value":"boo"}]`,
+		want:  `{"baz":"boo","foo":"bar"}`},
+	{name: "A.6 move a value", doc: `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+		patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+		want:  `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+	{name: "A.7 move an array element", doc: `{"foo":["all","grass","cows","eat"]}`,
+		patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+		want:  `{"foo":["all","cows","eat","grass"]}`},
+	{name: "A.8 test a value, success", doc: `{"baz":"qux","foo":["a",2,"c"]}`,
+		patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+		want:  `{"baz":"qux","foo":["a",2,"c"]}`},
+	{name: "A.9 test a value, error", doc: `{"baz":"qux"}`,
+		patch: `[{"op":"test","path":"/baz","value":"bar"}]`,
+		err:   ErrTestFailed},
+	{name: "A.10 add a nested member object", doc: `{"foo":"bar"}`,
+		patch: `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+		want:  `{"foo":"bar","child":{"grandchild":{}}}`},
+	{name: "A.11 ignore unrecognized elements", doc: `{"foo":"bar"}`,
+		patch: `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+		want:  `{"foo":"bar","baz":"qux"}`},
+	{name: "A.12 add to a nonexistent target", doc: `{"foo":"bar"}`,
+		patch: `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+		err:   ErrCannotApply},
+	{name: "A.14 ~ escape ordering", doc: `{"/":9,"~1":10}`,
+		patch: `[{"op":"test","path":"/~01","value":10}]`,
+		want:  `{"/":9,"~1":10}`},
+	{name: "A.15 compare strings and numbers", doc: `{"/":9,"~1":10}`,
+		patch: `[{"op":"test","path":"/~01","value":"10"}]`,
+		err:   ErrTestFailed},
+	{name: "A.16 add an array value", doc: `{"foo":["bar"]}`,
+		patch: `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+		want:  `{"foo":["bar",["abc","def"]]}`},
+
+	{name: "~1 is a slash", doc: `{}`,
+		patch: `[{"op":"add","path":"/a~1b","value":1}]`,
+		want:  `{"a/b":1}`},
+	{name: "~0 is a tilde", doc: `{}`,
+		patch: `[{"op":"add","path":"/a~0b","value":1}]`,
+		want:  `{"a~b":1}`},
+	{name: "- appends", doc: `{"a":[1,2]}`,
+		patch: `[{"op":"add","path":"/a/-","value":3}]`,
+		want:  `{"a":[1,2,3]}`},
+	{name: "add one past the end", doc: `{"a":[1,2]}`,
+		patch: `[{"op":"add","path":"/a/2","value":3}]`,
+		want:  `{"a":[1,2,3]}`},
+	{name: "add two past the end", doc: `{"a":[1,2]}`,
+		patch: `[{"op":"add","path":"/a/3","value":3}]`,
+		err:   ErrCannotApply},
+	{name: "leading zero", doc: `{"a":[1,2]}`,
+		patch: `[{"op":"add","path":"/a/01","value":3}]`,
+		err:   ErrCannotApply},
+	{name: "index 0 is not a leading zero", doc: `{"a":[1,2]}`,
+		patch: `[{"op":"remove","path":"/a/0"}]`,
+		want:  `{"a":[2]}`},
+	{name: "negative index", doc: `{"a":[1,2]}`,
+		patch: `[{"op":"remove","path":"/a/-1"}]`,
+		err:   ErrCannotApply},
+	{name: "remove -", doc: `{"a":[1,2]}`,
+		patch: `[{"op":"remove","path":"/a/-"}]`,
+		err:   ErrCannotApply},
+	{name: "test -", doc: `{"a":[1,2]}`,
+		patch: `[{"op":"test","path":"/a/-","value":2}]`,
+		err:   ErrCannotApply},
+	{name: "remove a missing member", doc: `{"a":1}`,
+		patch: `[{"op":"remove","path":"/b"}]`,
+		err:   ErrCannotApply},
+	{name: "replace a missing member", doc: `{"a":1}`,
+		patch: `[{"op":"replace","path":"/b","value":2}]`,
+		err:   ErrCannotApply},
+	{name: "replace the whole document", doc: `{"a":1}`,
+		patch: `[{"op":"replace","path":"","value":[1]}]`,
+		want:  `[1]`},
+	{name: "add the whole document", doc: `{"a":1}`,
+		patch: `[{"op":"add","path":"","value":{"b":2}}]`,
+		want:  `{"b":2}`},
+	{name: "remove the whole document", doc: `{"a":1}`,
+		patch: `[{"op":"remove","path":""}]`,
+		err:   ErrCannotApply},
+	{name: "move onto itself", doc: `{"a":{"b":1}}`,
+		patch: `[{"op":"move","from":"/a","path":"/a"}]`,
+		want:  `{"a":{"b":1}}`},
+	{name: "move into itself", doc: `{"a":{"b":1}}`,
+		patch: `[{"op":"move","from":"/a","path":"/a/b/c"}]`,
+		err:   ErrInvalidPatch},
+	{name: "move to a sibling with a common prefix", doc: `{"a":1}`,
+		patch: `[{"op":"move","from":"/a","path":"/ab"}]`,
+		want:  `{"ab":1}`},
+	{name: "copy is deep", doc: `{"a":{"b":1}}`,
+		patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"add","path":"/c/d","value":2}]`,
+		want:  `{"a":{"b":1},"c":{"b":1,"d":2}}`},
+	{name: "test 1 against 1.0", doc: `{"a":1}`,
+		patch: `[{"op":"test","path":"/a","value":1.0}]`,
+		want:  `{"a":1}`},
+	{name: "test 100 against 1e2", doc: `{"a":100}`,
+		patch: `[{"op":"test","path":"/a","value":1e2}]`,
+		want:  `{"a":100}`},
+	{name: "test objects regardless of member order", doc: `{"a":{"x":1,"y":[true,null]}}`,
+		patch: `[{"op":"test","path":"/a","value":{"y":[true,null],"x":1}}]`,
+		want:  `{"a":{"x":1,"y":[true,null]}}`},
+	{name: "test arrays in order", doc: `{"a":[1,2]}`,
+		patch: `[{"op":"test","path":"/a","value":[2,1]}]`,
+		err:   ErrTestFailed},
+	{name: "test a missing member", doc: `{"a":1}`,
+		patch: `[{"op":"test","path":"/b","value":1}]`,
+		err:   ErrCannotApply},
+	{name: "later operations see earlier ones", doc: `{"a":1}`,
+		patch: `[{"op":"add","path":"/b","value":2},{"op":"test","path":"/b","value":2}]`,
+		want:  `{"a":1,"b":2}`},
+	{name: "large integers survive", doc: `{"id":12345678901234567890,"a":1}`,
+		patch: `[{"op":"remove","path":"/a"}]`,
+		want:  `{"id":12345678901234567890}`},
+
+	{name: "not an array", doc: `{}`, patch: `{"op":"add","path":"/a","value":1}`, err: ErrInvalidPatch},
+	{name: "unknown op", doc: `{}`, patch: `[{"op":"frob","path":"/a"}]`, err: ErrInvalidPatch},
+	{name: "missing op", doc: `{}`, patch: `[{"path":"/a","value":1}]`, err: ErrInvalidPatch},
+	{name: "missing path", doc: `{}`, patch: `[{"op":"add","value":1}]`, err: ErrInvalidPatch},
+	{name: "missing value", doc: `{}`, patch: `[{"op":"add","path":"/a"}]`, err: ErrInvalidPatch},
+	{name: "null is a value", doc: `{}`, patch: `[{"op":"add","path":"/a","value":null}]`, want: `{"a":null}`},
+	{name: "missing from", doc: `{}`, patch: `[{"op":"copy","path":"/a"}]`, err: ErrInvalidPatch},
+	{name: "path without a slash", doc: `{}`, patch: `[{"op":"add","path":"a","value":1}]`, err: ErrInvalidPatch},
+}
+
+// mergePatchTests are the examples of RFC 7396 appendix A, in order
+var mergePatchTests = []patchTest{
+	{name: "A.1", doc: `{"a":"b"}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+	{name: "A.2", doc: `{"a":"b"}`, patch: `{"b":"c"}`, want: `{"a":"b","b":"c"}`},
+	{name: "A.3", doc: `{"a":"b"}`, patch: `{"a":null}`, want: `{}`},
+	{name: "A.4", doc: `{"a":"b","b":"c"}`, patch: `{"a":null}`, want: `{"b":"c"}`},
+	{name: "A.5", doc: `{"a":["b"]}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+	{name: "A.6", doc: `{"a":"c"}`, patch: `{"a":["b"]}`, want: `{"a":["b"]}`},
+	{name: "A.7", doc: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, want: `{"a":{"b":"d"}}`},
+	{name: "A.8", doc: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, want: `{"a":[1]}`},
+	{name: "A.9", doc: `["a","b"]`, patch: `["c","d"]`, want: `["c","d"]`},
+	{name: "A.10", doc: `{"a":"b"}`, patch: `["c"]`, want: `["c"]`},
+	{name: "A.11", doc: `{"a":"foo"}`, patch: `null`, want: `null`},
+	{name: "A.12", doc: `{"a":"foo"}`, patch: `"bar"`, want: `"bar"`},
+	{name: "A.13", doc: `{"e":null}`, patch: `{"a":1}`, want: `{"e":null,"a":1}`},
+	{name: "A.14", doc: `[1,2]`, patch: `{"a":"b","c":null}`, want: `{"a":"b"}`},
+	{name: "A.15", doc: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, want: `{"a":{"bb":{}}}`},
+
+	{name: "null for a missing member", doc: `{"a":1}`, patch: `{"b":null}`, want: `{"a":1}`},
+	{name: "arrays are replaced whole", doc: `{"a":[1,2,3]}`, patch: `{"a":[{"b":null}]}`, want: `{"a":[{"b":null}]}`},
+	{name: "large integers survive", doc: `{"id":12345678901234567890}`, patch: `{"a":1}`, want: `{"id":12345678901234567890,"a":1}`},
+	{name: "not JSON", doc: `{}`, patch: `{"a":`, err: ErrInvalidPatch},
+}
+
+func TestJSONPatch(t *testing.T) {
+	runPatchTests(t, JSONPatchContentType, jsonPatchTests)
+}
+
+func TestMergePatch(t *testing.T) {
+	runPatchTests(t, MergePatchContentType, mergePatchTests)
+}
+
+func runPatchTests(t *testing.T, contentType string, tests []patchTest) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := apply(contentType, test.doc, test.patch)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected %v, got %v (%s)", test.err, err, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertJSON(t, got, test.want)
+		})
+	}
+}
+
+// TestInvalidPatchDocument is RFC 6902 A.13, an op given twice.  The
+// last one wins when it is decoded, that is a remove of a member that is
+// not there, either way the patch must not go through.
+func TestInvalidPatchDocument(t *testing.T) {
+	_, err := apply(JSONPatchContentType, `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","op":"remove"}]`)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+// TestApplyAgain applies one patch twice, as a retried write does, and
+// expects the same result both times, nothing may be left behind in the
+// patch by the first run
+func TestApplyAgain(t *testing.T) {
+	for contentType, body := range map[string]string{
+		JSONPatchContentType:  `[{"op":"add","path":"/a","value":{"x":1}},{"op":"add","path":"/a/y","value":2}]`,
+		MergePatchContentType: `{"a":{"x":1,"y":{"z":null}}}`,
+	} {
+		p, err := Parse(contentType, []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		first, err := p.Apply([]byte(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := p.Apply([]byte(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertJSON(t, string(second), string(first))
+	}
+}
+
+func TestParseContentType(t *testing.T) {
+	for contentType, want := range map[string]error{
+		"application/json-patch+json; charset=utf-8": nil,
+		"application/merge-patch+json":               nil,
+		"application/json":                           ErrUnsupportedFormat,
+		"":                                           ErrUnsupportedFormat,
+	} {
+		if _, err := Parse(contentType, []byte(`[]`)); !errors.Is(err, want) {
+			t.Errorf("%q: expected %v, got %v", contentType, want, err)
+		}
+	}
+}
+
+func apply(contentType, doc, body string) (string, error) {
+	p, err := Parse(contentType, []byte(body))
+	if err != nil {
+		return "", err
+	}
+	out, err := p.Apply([]byte(doc))
+	return string(out), err
+}
+
+// assertJSON compares two JSON texts by what they hold, not how they are
+// written
+func assertJSON(t *testing.T, got, want string) {
+	t.Helper()
+	g, err := decode([]byte(got))
+	if err != nil {
+		t.Fatalf("result %s: %v", got, err)
+	}
+	w, err := decode([]byte(want))
+	if err != nil {
+		t.Fatalf("expected %s: %v", want, err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got %s, expected %s", got, want)
+	}
+}
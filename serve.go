@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/config"
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/metrics"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// runServe is the serve command, it runs the API until SIGINT or SIGTERM
+func runServe(args []string) error {
+	cfg, err := processCmdLineFlags(flag.NewFlagSet("serve", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	warnIfUnmigrated(store)
+
+	//Time every redis command as well as every storage call
+	if redisStore, ok := store.(*db.VoterList); ok {
+		redisStore.AddHook(metrics.RedisHook{})
+	}
+	apiHandler := api.New(metrics.InstrumentStore(store), cfg)
+
+	//Same as gin.Default() except that panics are reported as problem
+	//documents like every other error, and the access log is structured
+	//and tagged with the request id
+	r := gin.New()
+	r.Use(api.RequestID(), api.AccessLog())
+	r.Use(gin.CustomRecovery(apiHandler.Recover))
+	r.Use(apiHandler.CountRequests())
+	r.Use(metrics.Middleware())
+	r.Use(cors.Default())
+	r.Use(apiHandler.LimitBody())
+	//POSTs sent with an Idempotency-Key are answered once and replayed
+	//to retries, see api.Idempotency
+	r.Use(apiHandler.Idempotency())
+
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(apiHandler.NoRoute)
+	r.NoMethod(apiHandler.NoMethod)
+
+	// Liveness and readiness probes for docker and kubernetes, these used
+	// to live at /voters/health which clashed with /voters/:id
+	r.GET("/healthz", apiHandler.HealthCheck)
+	r.GET("/readyz", apiHandler.ReadyCheck)
+	// Prometheus scrape endpoint
+	r.GET("/metrics", metrics.Handler())
+
+	r.GET("/voters", apiHandler.GetAllVoterResources)
+	// Create a voter under the next free id, the answer says which
+	r.POST("/voters", apiHandler.CreateVoter)
+	// The whole roll as NDJSON, CSV or JSON, streamed, takes the same
+	// filters as /voters
+	r.GET("/voters/export", apiHandler.ExportVoters)
+
+	r.GET("/voters/:id", apiHandler.GetSingleVoterResource)
+	// Create a voters resource with id = :id, initialize the polls slice to an
+	// empty slice.  The body may leave the id out, if it has one it must be :id
+	r.POST("/voters/:id", apiHandler.AddVoter)
+	// Bulk import, POST /voters:batch with a CSV or NDJSON body, see
+	// VoterAction for why the route is spelled this way
+	r.POST("/voters:action", apiHandler.VoterAction)
+
+	r.GET("/voters/:id/polls", apiHandler.GetVoterHistory)
+
+	r.GET("/voters/:id/polls/:pollid", apiHandler.GetVoterPollData)
+	// Look up the voter with id = :id, then add the poll with pollid = :pollid to
+	// the internal poll slice
+	// POST /voters/22/polls/3
+	// Does voter 22 exist, if not return 404 error; if voter 22 exists, add
+	// pollid 3 to the internal poll slice
+	// ***** Does voter 22 exist, if not, create voter 22 (WHICH ASSUMES ALL THE
+	// VOTER INFO IS IN THE PAYLOAD), then voter 22, then
+	// add pollid 3 to the NEW voter 22 resource. If not, follow above
+	r.POST("/voters/:id/polls/:pollid", apiHandler.AddVoterPollData)
+
+	// Extra Credit
+	r.DELETE("/voters", apiHandler.DeleteAllVoters)
+
+	r.DELETE("/voters/:id", apiHandler.DeleteVoter)
+
+	r.DELETE("/voters/:id/polls/:pollid", apiHandler.DeletePoll)
+
+	r.PUT("/voters", apiHandler.UpdateVoter)
+	// Change some fields of a voter, the body is a JSON merge patch or
+	// a JSON patch, see api.PatchVoter
+	r.PATCH("/voters/:id", apiHandler.PatchVoter)
+
+	r.GET("/polls", apiHandler.GetAllPolls)
+	r.POST("/polls", apiHandler.AddPoll)
+	r.GET("/polls/:pollid", apiHandler.GetPoll)
+	// Also used to move a poll through its lifecycle, draft -> open ->
+	// closed -> archived
+	r.PUT("/polls/:pollid", apiHandler.UpdatePoll)
+	r.DELETE("/polls/:pollid", apiHandler.DeletePollResource)
+	// Who voted in a poll, backed by the poll index, rebuild it with
+	// the reindex command if it ever drifts
+	r.GET("/polls/:pollid/voters", apiHandler.GetPollVoters)
+	r.GET("/polls/:pollid/count", apiHandler.CountPollVoters)
+
+	// Turnout and activity reports, add ?format=csv for CSV
+	r.GET("/stats/polls", apiHandler.GetPollStatistics)
+	r.GET("/stats/polls/:pollid", apiHandler.GetPollReport)
+	r.GET("/stats/voters/top", apiHandler.GetTopVoters)
+
+	return serve(newServer(cfg.Server, r), store, cfg.Server.ShutdownGrace)
+}
+
+// warnIfUnmigrated points at the migrate command when the stored data is
+// older than this version, the API still starts since most migrations
+// only tidy up
+func warnIfUnmigrated(store db.VoterStore) {
+	version, err := store.SchemaVersion(context.Background())
+	if err != nil {
+		slog.Warn("cannot read the schema version", "error", err)
+		return
+	}
+	if version < db.SchemaVersion() {
+		slog.Warn("the stored data needs migrating, run the migrate command",
+			"version", version, "current", db.SchemaVersion())
+	}
+}
+
+// newServer wraps the router in an http.Server with the configured
+// timeouts, r.Run would leave them all unlimited
+func newServer(cfg config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Addr(),
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// serve runs the server until SIGINT or SIGTERM.  It then stops
+// accepting connections, gives the requests in flight grace to finish
+// (so a vote is never cut off halfway through its write) and closes the
+// store.
+func serve(srv *http.Server, store db.VoterStore, grace time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	failed := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+		close(failed)
+	}()
+
+	select {
+	case err := <-failed:
+		store.Close()
+		return err
+	case <-ctx.Done():
+	}
+	//A second signal kills the process straight away
+	stop()
+
+	slog.Info("shutting down", "grace", grace.String())
+	graceCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	if err := srv.Shutdown(graceCtx); err != nil {
+		slog.Warn("requests still running after the grace period", "error", err)
+	}
+	if err := store.Close(); err != nil {
+		slog.Warn("cannot close the voter store", "error", err)
+	}
+	slog.Info("stopped")
+	return nil
+}
@@ -0,0 +1,26 @@
+#!/bin/bash
+# Hammer a single voter with concurrent votes and check none were lost.
+#
+#   ./stress-votes.sh [voter id] [number of votes]
+#
+# All the votes go to one open poll that allows multiple votes, so the
+# voter should end up with exactly that many entries in its history.
+ID=${1:-1000}
+N=${2:-200}
+POLL=${POLL:-1000}
+URL=${URL:-http://localhost:1080}
+
+curl -s -o /dev/null -X DELETE $URL/polls/$POLL
+curl -s -o /dev/null -d "{ \"id\": $POLL, \"title\": \"Stress test\", \"status\": \"open\", \"votepolicy\": \"multiple\" }" -H "Content-Type: application/json" -X POST $URL/polls
+
+curl -s -o /dev/null -X DELETE $URL/voters/$ID
+curl -s -o /dev/null -d "{ \"id\": $ID, \"firstname\": \"Stress\", \"lastname\": \"Test\", \"votehistory\": [] }" -H "Content-Type: application/json" -X POST $URL/voters/$ID
+
+seq 1 $N | xargs -P 50 -I{} curl -s -o /dev/null -X POST $URL/voters/$ID/polls/$POLL
+
+GOT=$(curl -s $URL/voters/$ID/polls | grep -o '"pollid"' | wc -l)
+if [ "$GOT" -ne "$N" ]; then
+	echo "FAIL: expected $N votes, found $GOT"
+	exit 1
+fi
+echo "OK: all $N votes recorded"
@@ -0,0 +1,250 @@
+package voterio
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"drexel.edu/todo/db"
+)
+
+// csvHeader is the first row of every CSV file.  A CSV is one row per
+// vote so it can be filtered and pivoted in a spreadsheet, the rows of
+// one voter have to be next to each other when it is read back and the
+// names and profile are taken from the first of them.  Only the id
+// column is required, files written before the profile columns existed
+// still read.
+var csvHeader = []string{
+	"id", "firstname", "lastname",
+	"dateofbirth", "address.line1", "address.line2", "address.city", "address.state", "address.postalcode",
+	"district", "precinct", "email", "phone", "registrationdate",
+	"pollid", "votedate",
+}
+
+type csvDecoder struct {
+	r       *csv.Reader
+	columns map[string]int
+	row     int
+	start   int
+
+	//pending is the first row of the next voter, read while looking
+	//for the end of the current one
+	pending []string
+}
+
+// csvRow is one vote of a voter, or the whole voter if they never voted.
+// The voter has everything but the vote history.
+type csvRow struct {
+	voter db.Voter
+	vote  *db.VoterPoll
+}
+
+func (d *csvDecoder) Next() (db.Voter, error) {
+	if d.columns == nil {
+		if err := d.readHeader(); err != nil {
+			return db.Voter{}, err
+		}
+	}
+
+	first, err := d.read()
+	if err != nil {
+		return db.Voter{}, err
+	}
+	d.start = d.row
+	row, rowErr := d.parse(first)
+	if rowErr != nil && row.voter.VoterId == 0 {
+		//without an id the rows of this voter cannot be told apart
+		//from the next one's, the bad row stands on its own
+		return db.Voter{}, &RecordError{Record: d.start, Err: rowErr}
+	}
+
+	voter := row.voter
+	voter.VoteHistory = []db.VoterPoll{}
+	if row.vote != nil {
+		voter.VoteHistory = append(voter.VoteHistory, *row.vote)
+	}
+
+	//Keep reading until the id changes, the row that belongs to the
+	//next voter is put back for the next call
+	for {
+		next, err := d.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return db.Voter{}, err
+		}
+		more, err := d.parse(next)
+		if more.voter.VoterId != voter.VoterId {
+			d.pending = next
+			d.row--
+			break
+		}
+		if err != nil && rowErr == nil {
+			rowErr = err
+		}
+		if more.vote != nil {
+			voter.VoteHistory = append(voter.VoteHistory, *more.vote)
+		}
+	}
+
+	if rowErr != nil {
+		return db.Voter{}, &RecordError{Record: d.start, Err: rowErr}
+	}
+	return voter, nil
+}
+
+// Record is the first row of the voter, not counting the header
+func (d *csvDecoder) Record() int {
+	return d.start
+}
+
+func (d *csvDecoder) readHeader() error {
+	header, err := d.r.Read()
+	if err != nil {
+		return err
+	}
+	d.columns = make(map[string]int)
+	for i, name := range header {
+		d.columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := d.columns["id"]; !ok {
+		return errors.New("the CSV header has no id column")
+	}
+	return nil
+}
+
+// read returns the next data row.  A row with the wrong number of fields
+// is returned as it is, parse reports whatever is missing from it.
+func (d *csvDecoder) read() ([]string, error) {
+	d.row++
+	if d.pending != nil {
+		row := d.pending
+		d.pending = nil
+		return row, nil
+	}
+	row, err := d.r.Read()
+	if errors.Is(err, csv.ErrFieldCount) {
+		return row, nil
+	}
+	return row, err
+}
+
+func (d *csvDecoder) field(fields []string, name string) string {
+	i, ok := d.columns[name]
+	if !ok || i >= len(fields) {
+		return ""
+	}
+	return strings.TrimSpace(fields[i])
+}
+
+// parse reads one row.  The id is filled in even when the rest of the
+// row is invalid, so the row can still be matched to its voter.
+func (d *csvDecoder) parse(fields []string) (csvRow, error) {
+	id, err := strconv.ParseUint(d.field(fields, "id"), 10, 32)
+	if err != nil || id == 0 {
+		return csvRow{}, fmt.Errorf("id %q is not a voter id", d.field(fields, "id"))
+	}
+	row := csvRow{voter: db.Voter{
+		VoterId:   uint(id),
+		FirstName: d.field(fields, "firstname"),
+		LastName:  d.field(fields, "lastname"),
+		District:  d.field(fields, "district"),
+		Precinct:  d.field(fields, "precinct"),
+		Email:     d.field(fields, "email"),
+		Phone:     d.field(fields, "phone"),
+
+		DateOfBirth:      db.Date(d.field(fields, "dateofbirth")),
+		RegistrationDate: db.Date(d.field(fields, "registrationdate")),
+	}}
+	address := db.Address{
+		Line1:      d.field(fields, "address.line1"),
+		Line2:      d.field(fields, "address.line2"),
+		City:       d.field(fields, "address.city"),
+		State:      d.field(fields, "address.state"),
+		PostalCode: d.field(fields, "address.postalcode"),
+	}
+	if address != (db.Address{}) {
+		row.voter.Address = &address
+	}
+
+	pollS, dateS := d.field(fields, "pollid"), d.field(fields, "votedate")
+	if pollS == "" && dateS == "" {
+		return row, nil
+	}
+	pollId, err := strconv.ParseUint(pollS, 10, 32)
+	if err != nil || pollId == 0 {
+		return row, fmt.Errorf("pollid %q is not a poll id", pollS)
+	}
+	date, err := time.Parse(time.RFC3339Nano, dateS)
+	if err != nil {
+		return row, fmt.Errorf("votedate %q is not an RFC 3339 time", dateS)
+	}
+	row.vote = &db.VoterPoll{PollID: uint(pollId), VoteDate: date}
+	return row, nil
+}
+
+type csvEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (e *csvEncoder) Encode(voter db.Voter) error {
+	if !e.header {
+		if err := e.w.Write(csvHeader); err != nil {
+			return err
+		}
+		e.header = true
+	}
+
+	var address db.Address
+	if voter.Address != nil {
+		address = *voter.Address
+	}
+	voterColumns := []string{
+		strconv.FormatUint(uint64(voter.VoterId), 10),
+		voter.FirstName,
+		voter.LastName,
+		string(voter.DateOfBirth),
+		address.Line1,
+		address.Line2,
+		address.City,
+		address.State,
+		address.PostalCode,
+		voter.District,
+		voter.Precinct,
+		voter.Email,
+		voter.Phone,
+		string(voter.RegistrationDate),
+	}
+
+	if len(voter.VoteHistory) == 0 {
+		return e.w.Write(append(voterColumns, "", ""))
+	}
+	for _, vote := range voter.VoteHistory {
+		row := append(voterColumns,
+			strconv.FormatUint(uint64(vote.PollID), 10),
+			vote.VoteDate.UTC().Format(time.RFC3339Nano),
+		)
+		if err := e.w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close writes the header if no voter was, so an empty export is still
+// a valid CSV
+func (e *csvEncoder) Close() error {
+	if !e.header {
+		if err := e.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
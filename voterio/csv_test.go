@@ -0,0 +1,207 @@
+package voterio
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+)
+
+// decoded is what a decoder gave back for one record
+type decoded struct {
+	voter  db.Voter
+	record int
+	err    bool
+}
+
+func decodeAll(t *testing.T, input, format string) []decoded {
+	t.Helper()
+	dec, err := NewDecoder(strings.NewReader(input), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []decoded
+	for {
+		voter, err := dec.Next()
+		if err == io.EOF {
+			return out
+		}
+		var recErr *RecordError
+		if errors.As(err, &recErr) {
+			out = append(out, decoded{record: recErr.Record, err: true})
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, decoded{voter: voter, record: dec.Record()})
+	}
+}
+
+func vote(pollId uint, date string) db.VoterPoll {
+	t, err := time.Parse(time.RFC3339Nano, date)
+	if err != nil {
+		panic(err)
+	}
+	return db.VoterPoll{PollID: pollId, VoteDate: t}
+}
+
+func TestCSVDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []decoded
+	}{
+		{
+			name: "one row per vote",
+			input: "id,firstname,lastname,district,address.city,pollid,votedate\n" +
+				"1,Ann,Smith,D1,Philadelphia,1,2024-03-01T10:00:00.123456789Z\n" +
+				"1,Ann,Smith,D1,Philadelphia,2,2024-03-02T10:00:00Z\n" +
+				"2,Bob,Jones,,,,\n" +
+				"3,Cy,Brown,,,4,2024-03-04T10:00:00Z\n",
+			want: []decoded{
+				{record: 1, voter: db.Voter{VoterId: 1, FirstName: "Ann", LastName: "Smith", District: "D1",
+					Address: &db.Address{City: "Philadelphia"},
+					VoteHistory: []db.VoterPoll{
+						vote(1, "2024-03-01T10:00:00.123456789Z"),
+						vote(2, "2024-03-02T10:00:00Z"),
+					}}},
+				{record: 3, voter: db.Voter{VoterId: 2, FirstName: "Bob", LastName: "Jones", VoteHistory: []db.VoterPoll{}}},
+				{record: 4, voter: db.Voter{VoterId: 3, FirstName: "Cy", LastName: "Brown",
+					VoteHistory: []db.VoterPoll{vote(4, "2024-03-04T10:00:00Z")}}},
+			},
+		},
+		{
+			//The names come from the first row of a voter
+			name: "names from the first row",
+			input: "id,firstname,lastname,pollid,votedate\n" +
+				"1,Ann,Smith,1,2024-03-01T10:00:00Z\n" +
+				"1,Bea,Jones,2,2024-03-02T10:00:00Z\n",
+			want: []decoded{
+				{record: 1, voter: db.Voter{VoterId: 1, FirstName: "Ann", LastName: "Smith", VoteHistory: []db.VoterPoll{
+					vote(1, "2024-03-01T10:00:00Z"),
+					vote(2, "2024-03-02T10:00:00Z"),
+				}}},
+			},
+		},
+		{
+			//A voter whose rows are split up reads as two voters, the
+			//import reports the second as a duplicate
+			name: "rows apart",
+			input: "id,firstname,lastname,pollid,votedate\n" +
+				"1,Ann,Smith,1,2024-03-01T10:00:00Z\n" +
+				"2,Bob,Jones,,\n" +
+				"1,Ann,Smith,2,2024-03-02T10:00:00Z\n",
+			want: []decoded{
+				{record: 1, voter: db.Voter{VoterId: 1, FirstName: "Ann", LastName: "Smith",
+					VoteHistory: []db.VoterPoll{vote(1, "2024-03-01T10:00:00Z")}}},
+				{record: 2, voter: db.Voter{VoterId: 2, FirstName: "Bob", LastName: "Jones", VoteHistory: []db.VoterPoll{}}},
+				{record: 3, voter: db.Voter{VoterId: 1, FirstName: "Ann", LastName: "Smith",
+					VoteHistory: []db.VoterPoll{vote(2, "2024-03-02T10:00:00Z")}}},
+			},
+		},
+		{
+			//A bad row rejects its voter with all of its rows, the next
+			//voter reads as usual
+			name: "bad vote",
+			input: "id,firstname,lastname,pollid,votedate\n" +
+				"1,Ann,Smith,1,2024-03-01T10:00:00Z\n" +
+				"1,Ann,Smith,2,yesterday\n" +
+				"1,Ann,Smith,3,2024-03-03T10:00:00Z\n" +
+				"2,Bob,Jones,x,2024-03-03T10:00:00Z\n" +
+				"3,Cy,Brown,,\n",
+			want: []decoded{
+				{record: 1, err: true},
+				{record: 4, err: true},
+				{record: 5, voter: db.Voter{VoterId: 3, FirstName: "Cy", LastName: "Brown", VoteHistory: []db.VoterPoll{}}},
+			},
+		},
+		{
+			//Without an id a row cannot be matched to a voter, it is
+			//rejected on its own
+			name: "bad id",
+			input: "id,firstname,lastname,pollid,votedate\n" +
+				"x,Ann,Smith,,\n" +
+				"0,Ann,Smith,,\n" +
+				"2,Bob,Jones,,\n",
+			want: []decoded{
+				{record: 1, err: true},
+				{record: 2, err: true},
+				{record: 3, voter: db.Voter{VoterId: 2, FirstName: "Bob", LastName: "Jones", VoteHistory: []db.VoterPoll{}}},
+			},
+		},
+		{
+			name: "short row",
+			input: "id,firstname,lastname,pollid,votedate\n" +
+				"1,Ann\n" +
+				"2,Bob,Jones,1\n" +
+				"3,Cy,Brown,,\n",
+			want: []decoded{
+				{record: 1, voter: db.Voter{VoterId: 1, FirstName: "Ann", VoteHistory: []db.VoterPoll{}}},
+				{record: 2, err: true},
+				{record: 3, voter: db.Voter{VoterId: 3, FirstName: "Cy", LastName: "Brown", VoteHistory: []db.VoterPoll{}}},
+			},
+		},
+		{
+			name:  "header only",
+			input: strings.Join(csvHeader, ",") + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := decodeAll(t, test.input, FormatCSV)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected\n%+v\ngot\n%+v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestCSVDecodeHeader(t *testing.T) {
+	for _, input := range []string{"", "firstname,lastname\nAnn,Smith\n"} {
+		dec, _ := NewDecoder(strings.NewReader(input), FormatCSV)
+		_, err := dec.Next()
+		var recErr *RecordError
+		if err == nil || errors.As(err, &recErr) {
+			t.Errorf("%q: expected the import to stop, got %v", input, err)
+		}
+	}
+}
+
+// TestRecordError checks JSON and NDJSON go on after a record that
+// cannot be decoded, and JSON stops at one it cannot get past
+func TestRecordError(t *testing.T) {
+	ann := db.Voter{VoterId: 1, FirstName: "Ann", LastName: "Smith"}
+	bob := db.Voter{VoterId: 2, FirstName: "Bob", LastName: "Jones"}
+
+	got := decodeAll(t, `[{"id":1,"firstname":"Ann","lastname":"Smith"}, {"id":"x"}, `+
+		`{"id":2,"firstname":"Bob","lastname":"Jones"}]`, FormatJSON)
+	want := []decoded{{record: 1, voter: ann}, {record: 2, err: true}, {record: 3, voter: bob}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("json: expected\n%+v\ngot\n%+v", want, got)
+	}
+
+	got = decodeAll(t, `{"id":1,"firstname":"Ann","lastname":"Smith"}`+"\n{\"id\":\n\n"+
+		`{"id":2,"firstname":"Bob","lastname":"Jones"}`+"\n", FormatNDJSON)
+	want = []decoded{{record: 1, voter: ann}, {record: 2, err: true}, {record: 3, voter: bob}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ndjson: expected\n%+v\ngot\n%+v", want, got)
+	}
+
+	for _, input := range []string{`{"id":1}`, `[{"id":1,}, {"id":2}]`} {
+		dec, _ := NewDecoder(strings.NewReader(input), FormatJSON)
+		var err error
+		for err == nil {
+			_, err = dec.Next()
+		}
+		var recErr *RecordError
+		if err == io.EOF || errors.As(err, &recErr) {
+			t.Errorf("%s: expected the import to stop, got %v", input, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package voterio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+
+	"drexel.edu/todo/db"
+)
+
+// DefaultBatchSize is how many voters Import hands to db.AddVoters at a
+// time
+const DefaultBatchSize = 500
+
+// What happened to each record of an import
+const (
+	ResultCreated   = "created"
+	ResultUpdated   = "updated"
+	ResultDuplicate = "duplicate"
+	ResultRejected  = "rejected"
+)
+
+// ImportOptions change how Import writes the voters it reads
+type ImportOptions struct {
+	//DryRun validates and checks for duplicates but writes nothing, the
+	//report says what would have happened
+	DryRun bool
+	//Upsert replaces voters that already exist instead of skipping
+	//them, each replacement is a write of its own
+	Upsert bool
+	//BatchSize is the number of voters written at a time, 0 means
+	//DefaultBatchSize
+	BatchSize int
+	//Rules are the configured vote policies, the rules a voter is
+	//validated against depend on them, see db.VoterValidator
+	Rules db.VotingRules
+}
+
+// RecordResult is the outcome for one record of the input
+type RecordResult struct {
+	Record  int    `json:"record"`
+	VoterID uint   `json:"id,omitempty"`
+	Result  string `json:"result"`
+	Reason  string `json:"reason,omitempty"`
+	//Errors lists the rules a rejected voter breaks, field by field
+	Errors []db.FieldError `json:"errors,omitempty"`
+}
+
+// ImportReport sums up an import, Records has an entry for every record
+// read in the order they were read, see Import
+type ImportReport struct {
+	DryRun     bool           `json:"dryrun"`
+	Created    int            `json:"created"`
+	Updated    int            `json:"updated"`
+	Duplicates int            `json:"duplicates"`
+	Rejected   int            `json:"rejected"`
+	Records    []RecordResult `json:"records"`
+}
+
+func (r *ImportReport) add(res RecordResult) {
+	switch res.Result {
+	case ResultCreated:
+		r.Created++
+	case ResultUpdated:
+		r.Updated++
+	case ResultDuplicate:
+		r.Duplicates++
+	case ResultRejected:
+		r.Rejected++
+	}
+	r.Records = append(r.Records, res)
+}
+
+// sort puts the records back in input order, rejected records are
+// reported straight away while the others wait for their batch
+func (r *ImportReport) sort() {
+	sort.SliceStable(r.Records, func(i, j int) bool {
+		return r.Records[i].Record < r.Records[j].Record
+	})
+}
+
+// Import reads every voter from dec and adds them to store a batch at a
+// time.  Records that cannot be decoded or are not valid voters are
+// rejected, voters that already exist are duplicates, neither stops the
+// import.  A storage error or input that cannot be read any further
+// does, the report then covers what was done up to that point and the
+// batches before it have been written.
+func Import(ctx context.Context, store db.VoterStore, dec Decoder, opts ImportOptions) (ImportReport, error) {
+	size := opts.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	validator := db.NewVoterValidator(store, opts.Rules)
+
+	report := ImportReport{DryRun: opts.DryRun, Records: []RecordResult{}}
+	batch := make([]db.Voter, 0, size)
+	records := make([]int, 0, size)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		results, err := store.AddVoters(ctx, batch, opts.DryRun)
+		if err != nil {
+			return err
+		}
+		for i, voter := range batch {
+			res := RecordResult{Record: records[i], VoterID: voter.VoterId, Result: ResultCreated}
+			switch {
+			case results[i] == nil:
+			case errors.Is(results[i], db.ErrVoterExists) && opts.Upsert:
+				if !opts.DryRun {
+					if err := store.UpdateVoter(ctx, voter); err != nil {
+						return err
+					}
+				}
+				res.Result = ResultUpdated
+			case errors.Is(results[i], db.ErrVoterExists):
+				res.Result = ResultDuplicate
+				res.Reason = results[i].Error()
+			default:
+				res.Result = ResultRejected
+				res.Reason = results[i].Error()
+			}
+			report.add(res)
+		}
+		batch, records = batch[:0], records[:0]
+		return nil
+	}
+
+	for {
+		voter, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		var recErr *RecordError
+		if errors.As(err, &recErr) {
+			report.add(RecordResult{Record: recErr.Record, Result: ResultRejected, Reason: recErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			if ferr := flush(); ferr != nil {
+				err = ferr
+			}
+			report.sort()
+			return report, err
+		}
+
+		if voter.VoteHistory == nil {
+			voter.VoteHistory = []db.VoterPoll{}
+		}
+		var invalid *db.ValidationError
+		if err := validator.Validate(ctx, voter); errors.As(err, &invalid) {
+			report.add(RecordResult{Record: dec.Record(), VoterID: voter.VoterId, Result: ResultRejected,
+				Reason: err.Error(), Errors: invalid.Fields})
+			continue
+		} else if err != nil {
+			if ferr := flush(); ferr != nil {
+				err = ferr
+			}
+			report.sort()
+			return report, err
+		}
+
+		batch = append(batch, voter)
+		records = append(records, dec.Record())
+		if len(batch) == size {
+			if err := flush(); err != nil {
+				report.sort()
+				return report, err
+			}
+		}
+	}
+
+	err := flush()
+	report.sort()
+	return report, err
+}
@@ -0,0 +1,266 @@
+package voterio
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+)
+
+// importInput has a voter of every kind, in NDJSON
+var importInput = strings.Join([]string{
+	`{"id":1,"firstname":"Ann","lastname":"Smith"}`,
+	`{"id":`,
+	`{"id":2,"firstname":"Bob","lastname":"Jones"}`,
+	`{"id":3,"firstname":"Cy"}`,
+	`{"id":1,"firstname":"Ann","lastname":"Brown"}`,
+	`{"id":4,"firstname":"Di","lastname":"Silva"}`,
+	`{"id":9,"firstname":"Ed","lastname":"Khan"}`,
+}, "\n")
+
+// results is the outcome of every record of a report, in order
+func results(report ImportReport) []string {
+	var out []string
+	for _, res := range report.Records {
+		out = append(out, res.Result)
+	}
+	return out
+}
+
+func runImport(t *testing.T, store db.VoterStore, input, format string, opts ImportOptions) ImportReport {
+	t.Helper()
+	dec, err := NewDecoder(strings.NewReader(input), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Import(context.Background(), store, dec, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// newImportStore holds voter 9, which the import already finds there
+func newImportStore(t *testing.T) *db.MemoryVoterList {
+	t.Helper()
+	store := db.NewMemoryVoterList()
+	if _, err := store.AddVoter(context.Background(), *db.NewVoter(9, "Fay", "Chen")); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    ImportOptions
+		results []string
+		//names is the last name of every voter stored after the import
+		names map[uint]string
+	}{
+		{
+			name: "import",
+			results: []string{ResultCreated, ResultRejected, ResultCreated, ResultRejected,
+				ResultDuplicate, ResultCreated, ResultDuplicate},
+			names: map[uint]string{1: "Smith", 2: "Jones", 4: "Silva", 9: "Chen"},
+		},
+		{
+			//The batches end in the middle of the input, a duplicate of
+			//a voter in an earlier batch is still a duplicate
+			name: "small batches",
+			opts: ImportOptions{BatchSize: 2},
+			results: []string{ResultCreated, ResultRejected, ResultCreated, ResultRejected,
+				ResultDuplicate, ResultCreated, ResultDuplicate},
+			names: map[uint]string{1: "Smith", 2: "Jones", 4: "Silva", 9: "Chen"},
+		},
+		{
+			name: "dry run",
+			opts: ImportOptions{DryRun: true},
+			results: []string{ResultCreated, ResultRejected, ResultCreated, ResultRejected,
+				ResultDuplicate, ResultCreated, ResultDuplicate},
+			names: map[uint]string{9: "Chen"},
+		},
+		{
+			name: "upsert",
+			opts: ImportOptions{Upsert: true, BatchSize: 2},
+			results: []string{ResultCreated, ResultRejected, ResultCreated, ResultRejected,
+				ResultUpdated, ResultCreated, ResultUpdated},
+			names: map[uint]string{1: "Brown", 2: "Jones", 4: "Silva", 9: "Khan"},
+		},
+		{
+			name: "upsert dry run",
+			opts: ImportOptions{Upsert: true, DryRun: true},
+			results: []string{ResultCreated, ResultRejected, ResultCreated, ResultRejected,
+				ResultUpdated, ResultCreated, ResultUpdated},
+			names: map[uint]string{9: "Chen"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newImportStore(t)
+			report := runImport(t, store, importInput, FormatNDJSON, test.opts)
+
+			if got := results(report); !reflect.DeepEqual(got, test.results) {
+				t.Errorf("expected %v, got %v", test.results, got)
+			}
+			for i, res := range report.Records {
+				if res.Record != i+1 {
+					t.Errorf("expected record %d, got %d", i+1, res.Record)
+				}
+			}
+			if report.DryRun != test.opts.DryRun {
+				t.Errorf("expected dryrun %v, got %v", test.opts.DryRun, report.DryRun)
+			}
+			counts := [4]int{report.Created, report.Updated, report.Duplicates, report.Rejected}
+			var want [4]int
+			for _, r := range test.results {
+				want[map[string]int{ResultCreated: 0, ResultUpdated: 1, ResultDuplicate: 2, ResultRejected: 3}[r]]++
+			}
+			if counts != want {
+				t.Errorf("expected created, updated, duplicates, rejected %v, got %v", want, counts)
+			}
+			//The invalid voter says what is wrong with it
+			if rejected := report.Records[3]; len(rejected.Errors) != 1 || rejected.Errors[0].Field != "lastname" {
+				t.Errorf("expected the lastname to be reported, got %+v", rejected.Errors)
+			}
+
+			voters, err := store.GetAllVoters(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := make(map[uint]string)
+			for _, voter := range voters {
+				names[voter.VoterId] = voter.LastName
+			}
+			if !reflect.DeepEqual(names, test.names) {
+				t.Errorf("expected the store to hold %v, got %v", test.names, names)
+			}
+		})
+	}
+}
+
+// roundTripVoters covers every field an export writes, vote dates down
+// to the nanosecond included
+func roundTripVoters() []db.Voter {
+	at := func(s string) time.Time {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	return []db.Voter{
+		{
+			VoterId: 1, FirstName: "Ann", LastName: "Smith",
+			VoteHistory: []db.VoterPoll{
+				{PollID: 1, VoteDate: at("2024-03-01T10:00:00.123456789Z")},
+				{PollID: 2, VoteDate: at("2024-03-02T10:00:00Z")},
+			},
+			DateOfBirth: "1980-05-17",
+			Address: &db.Address{Line1: "1 Main St", Line2: "Apt, 2", City: "Philadelphia",
+				State: "PA", PostalCode: "19104"},
+			District: "D1", Precinct: "P7", Email: "ann@example.com", Phone: "+12155550123",
+			RegistrationDate: "2000-01-03",
+		},
+		{VoterId: 2, FirstName: "Bob", LastName: "O'Neil", VoteHistory: []db.VoterPoll{}},
+		{
+			VoterId: 3, FirstName: "Cy", LastName: "Brown",
+			VoteHistory: []db.VoterPoll{{PollID: 3, VoteDate: at("2024-03-03T10:00:00.000000001Z")}},
+			Address:     &db.Address{Line1: "9 Elm St", City: "Pittsburgh", State: "PA", PostalCode: "15213"},
+		},
+	}
+}
+
+func export(t *testing.T, store db.VoterStore, format string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	enc, err := NewEncoder(&buf, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.ScanVoters(context.Background(), db.VoterQuery{}, func(voter db.Voter) error {
+		return enc.Encode(voter)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// TestRoundTrip exports a store and imports the export into an empty
+// one, which has to end up holding the same voters
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatNDJSON, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			from := db.NewMemoryVoterList()
+			for _, voter := range roundTripVoters() {
+				if _, err := from.AddVoter(context.Background(), voter); err != nil {
+					t.Fatal(err)
+				}
+			}
+			exported := export(t, from, format)
+
+			to := db.NewMemoryVoterList()
+			report := runImport(t, to, exported, format, ImportOptions{})
+			if report.Created != 3 || len(report.Records) != 3 {
+				t.Fatalf("expected 3 voters created, got %+v", report)
+			}
+
+			if again := export(t, to, format); again != exported {
+				t.Errorf("the import changed the voters, exported\n%s\nthen\n%s", exported, again)
+			}
+			for _, want := range roundTripVoters() {
+				got, err := to.GetSingleVoterResource(context.Background(), want.VoterId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(got.VoteHistory) != len(want.VoteHistory) {
+					t.Fatalf("voter %d: expected %d votes, got %d", want.VoterId, len(want.VoteHistory), len(got.VoteHistory))
+				}
+				for i, vote := range got.VoteHistory {
+					if !vote.VoteDate.Equal(want.VoteHistory[i].VoteDate) {
+						t.Errorf("voter %d: expected a vote at %v, got %v", want.VoterId,
+							want.VoteHistory[i].VoteDate, vote.VoteDate)
+					}
+				}
+				if !reflect.DeepEqual(got.Address, want.Address) || got.Phone != want.Phone ||
+					got.DateOfBirth != want.DateOfBirth || got.LastName != want.LastName {
+					t.Errorf("voter %d: expected %+v, got %+v", want.VoterId, want, got)
+				}
+			}
+		})
+	}
+}
+
+// TestRoundTripAudit checks the formats that carry the vote audit trail
+// keep it
+func TestRoundTripAudit(t *testing.T) {
+	changed := time.Date(2024, 3, 2, 10, 0, 0, 42, time.UTC)
+	voter := db.Voter{VoterId: 1, FirstName: "Ann", LastName: "Smith",
+		VoteHistory: []db.VoterPoll{{PollID: 1, VoteDate: changed}},
+		VoteAudit:   []db.VoteChange{{PollID: 1, PreviousDate: changed.Add(-time.Hour), ChangedAt: changed}},
+	}
+	for _, format := range []string{FormatJSON, FormatNDJSON} {
+		from := db.NewMemoryVoterList()
+		if _, err := from.AddVoter(context.Background(), voter); err != nil {
+			t.Fatal(err)
+		}
+		to := db.NewMemoryVoterList()
+		runImport(t, to, export(t, from, format), format, ImportOptions{})
+		got, err := to.GetSingleVoterResource(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.VoteAudit) != 1 || !got.VoteAudit[0].PreviousDate.Equal(voter.VoteAudit[0].PreviousDate) {
+			t.Errorf("%s: expected the audit trail %+v, got %+v", format, voter.VoteAudit, got.VoteAudit)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Package voterio reads and writes voters in the formats the bulk
+// import and export understand:
+//
+//	json     a JSON array of voters, exactly as the API returns them
+//	ndjson   one voter per line, the easiest to stream and to append to
+//	csv      id,firstname,lastname, the profile, pollid,votedate with a
+//	         header row and one row per vote, a voter who never voted
+//	         gets one row with the poll columns empty, see csv.go
+//
+// JSON and NDJSON carry every field.  CSV is meant for spreadsheets and
+// leaves out the vote audit trail.
+package voterio
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"drexel.edu/todo/db"
+)
+
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// ErrUnknownFormat is returned for a format that is not one of the
+// Format constants
+var ErrUnknownFormat = errors.New("unknown format, use json, ndjson or csv")
+
+// FormatFromPath guesses the format from a file extension, .jsonl
+// counts as NDJSON.  It returns "" if it cannot tell.
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	case ".csv":
+		return FormatCSV
+	default:
+		return ""
+	}
+}
+
+// FormatFromContentType maps a media type to a format, "" if it is not
+// one we read or write
+func FormatFromContentType(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(strings.ToLower(mediaType)) {
+	case "application/json":
+		return FormatJSON
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return FormatNDJSON
+	case "text/csv":
+		return FormatCSV
+	default:
+		return ""
+	}
+}
+
+// ContentType is the media type to send a format with
+func ContentType(format string) string {
+	switch format {
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	default:
+		return "application/json"
+	}
+}
+
+// RecordError is a record that could not be decoded.  Decoding can go
+// on with the next record after one of these, any other error ends it.
+type RecordError struct {
+	//Record is the 1 based position of the record, for CSV it is the
+	//first row of the voter, not counting the header
+	Record int
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("record %d: %v", e.Record, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// Decoder reads voters one at a time, Next returns io.EOF after the last
+// one.  Record is the position of the record Next returned last,
+// counted the same way as RecordError.Record.
+type Decoder interface {
+	Next() (db.Voter, error)
+	Record() int
+}
+
+// NewDecoder returns a Decoder reading format from r
+func NewDecoder(r io.Reader, format string) (Decoder, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonDecoder{dec: json.NewDecoder(r)}, nil
+	case FormatNDJSON:
+		sc := bufio.NewScanner(r)
+		//a voter with a long history can be a long line
+		sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		return &ndjsonDecoder{sc: sc}, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		return &csvDecoder{r: cr}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+type jsonDecoder struct {
+	dec     *json.Decoder
+	started bool
+	n       int
+}
+
+func (d *jsonDecoder) Next() (db.Voter, error) {
+	if !d.started {
+		tok, err := d.dec.Token()
+		if err == io.EOF {
+			return db.Voter{}, io.EOF
+		}
+		if err != nil {
+			return db.Voter{}, err
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			return db.Voter{}, errors.New("expected a JSON array of voters")
+		}
+		d.started = true
+	}
+
+	if !d.dec.More() {
+		return db.Voter{}, io.EOF
+	}
+	d.n++
+	var voter db.Voter
+	if err := d.dec.Decode(&voter); err != nil {
+		//a syntax error leaves the decoder lost, only a value of the
+		//wrong type can be skipped
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return db.Voter{}, &RecordError{Record: d.n, Err: err}
+		}
+		return db.Voter{}, err
+	}
+	return voter, nil
+}
+
+func (d *jsonDecoder) Record() int {
+	return d.n
+}
+
+type ndjsonDecoder struct {
+	sc *bufio.Scanner
+	n  int
+}
+
+func (d *ndjsonDecoder) Next() (db.Voter, error) {
+	for d.sc.Scan() {
+		line := strings.TrimSpace(d.sc.Text())
+		if line == "" {
+			continue
+		}
+		d.n++
+		var voter db.Voter
+		if err := json.Unmarshal([]byte(line), &voter); err != nil {
+			return db.Voter{}, &RecordError{Record: d.n, Err: err}
+		}
+		return voter, nil
+	}
+	if err := d.sc.Err(); err != nil {
+		return db.Voter{}, err
+	}
+	return db.Voter{}, io.EOF
+}
+
+func (d *ndjsonDecoder) Record() int {
+	return d.n
+}
+
+// Encoder writes voters one at a time, Close finishes the document (for
+// JSON the closing bracket) and flushes it but does not close the
+// underlying writer
+type Encoder interface {
+	Encode(voter db.Voter) error
+	Close() error
+}
+
+// NewEncoder returns an Encoder writing format to w
+func NewEncoder(w io.Writer, format string) (Encoder, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonEncoder{w: bufio.NewWriter(w)}, nil
+	case FormatNDJSON:
+		bw := bufio.NewWriter(w)
+		return &ndjsonEncoder{w: bw, enc: json.NewEncoder(bw)}, nil
+	case FormatCSV:
+		return &csvEncoder{w: csv.NewWriter(w)}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+type jsonEncoder struct {
+	w *bufio.Writer
+	n int
+}
+
+func (e *jsonEncoder) Encode(voter db.Voter) error {
+	b, err := json.Marshal(voter)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if e.n == 0 {
+		sep = "[\n"
+	}
+	e.n++
+	if _, err := e.w.WriteString(sep); err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *jsonEncoder) Close() error {
+	end := "\n]\n"
+	if e.n == 0 {
+		end = "[]\n"
+	}
+	if _, err := e.w.WriteString(end); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+type ndjsonEncoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) Encode(voter db.Voter) error {
+	return e.enc.Encode(voter)
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return e.w.Flush()
+}